@@ -0,0 +1,142 @@
+package socketigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	ErrAckTimeout         = errors.New("operation has timed out")
+	ErrSocketDisconnected = errors.New("socket has been disconnected")
+)
+
+// AckResponse holds the arguments a client sent back for an acknowledged emit.
+type AckResponse struct {
+	packet *Packet
+	parser Parser
+}
+
+// NewAckResponse wraps acknowledgement arguments received by other means than
+// the socket's connection, e.g. relayed by a distributed adapter.
+func NewAckResponse(nsp *Namespace, args ...interface{}) *AckResponse {
+	return &AckResponse{
+		packet: &Packet{
+			Type:      PacketAck,
+			Namespace: nsp.Name(),
+			Data:      args,
+			DataKind:  reflect.Slice,
+		},
+		parser: nsp.parser,
+	}
+}
+
+// Args returns the acknowledgement arguments as decoded by the parser.
+func (r *AckResponse) Args() []interface{} {
+	args, _ := r.packet.Data.([]interface{})
+	return args
+}
+
+// Decode decodes the acknowledgement arguments into the given pointers, in order.
+func (r *AckResponse) Decode(v ...interface{}) error {
+	types := make([]reflect.Type, len(v))
+	for i := range v {
+		rt := reflect.TypeOf(v[i])
+		if rt == nil || rt.Kind() != reflect.Pointer {
+			return fmt.Errorf("ack decode target %d is not a pointer: %T", i, v[i])
+		}
+		types[i] = rt.Elem()
+	}
+
+	values, err := r.parser.ParseAckArgs(r.packet, types, false)
+	if err != nil {
+		return err
+	}
+
+	for i := range values {
+		reflect.ValueOf(v[i]).Elem().Set(values[i])
+	}
+	return nil
+}
+
+type AckCallback func(resp *AckResponse, err error)
+
+type pendingAck struct {
+	callback AckCallback
+	done     chan struct{}
+}
+
+func ackError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrAckTimeout
+	}
+	return ctx.Err()
+}
+
+// registerAck returns false once the socket is disconnected, in which case
+// callback is never called.
+func (s *Socket) registerAck(ctx context.Context, id int, callback AckCallback) bool {
+	ack := &pendingAck{
+		callback: callback,
+		done:     make(chan struct{}),
+	}
+
+	s.ackLock.Lock()
+	if s.acksClosed {
+		s.ackLock.Unlock()
+		return false
+	}
+	s.acks[id] = ack
+	s.ackLock.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			if ack := s.takeAck(id); ack != nil {
+				ack.callback(nil, ackError(ctx))
+			}
+		case <-ack.done:
+		}
+	}()
+	return true
+}
+
+func (s *Socket) takeAck(id int) *pendingAck {
+	s.ackLock.Lock()
+	defer s.ackLock.Unlock()
+
+	ack, ok := s.acks[id]
+	if !ok {
+		return nil
+	}
+	delete(s.acks, id)
+	close(ack.done)
+	return ack
+}
+
+func (s *Socket) onAck(packet *Packet) {
+	if packet.Id == nil {
+		return
+	}
+
+	ack := s.takeAck(*packet.Id)
+	if ack == nil {
+		s.logger.Debugf("Unknown ack id %v", *packet.Id)
+		return
+	}
+	ack.callback(&AckResponse{packet: packet, parser: s.conn.parser}, nil)
+}
+
+func (s *Socket) clearAcks() {
+	s.ackLock.Lock()
+	acks := s.acks
+	s.acks = make(map[int]*pendingAck)
+	s.acksClosed = true
+	s.ackLock.Unlock()
+
+	for _, ack := range acks {
+		close(ack.done)
+		ack.callback(nil, ErrSocketDisconnected)
+	}
+}
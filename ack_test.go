@@ -0,0 +1,65 @@
+package socketigo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Emits racing with a disconnection all complete with ErrSocketDisconnected,
+// none of them being left pending.
+func TestEmitWithAckDisconnect(t *testing.T) {
+	const n = 50
+
+	srv, url := newTestServer(t)
+	sockets := make(chan *Socket, 1)
+	errs := make(chan error, n+1)
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		go func() {
+			_, err := socket.EmitWithAck(context.Background(), "pending")
+			errs <- err
+		}()
+		for i := 0; i < n; i++ {
+			go func() {
+				for {
+					ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+					_, err := socket.EmitWithAck(ctx, "ping")
+					cancel()
+					if !errors.Is(err, ErrAckTimeout) {
+						errs <- err
+						return
+					}
+				}
+			}()
+		}
+		sockets <- socket
+	})
+	c := connect(t, url, "/")
+	go func() {
+		// Keeps the polling transport flowing.
+		for {
+			if _, err := c.Expect(time.Second); err != nil {
+				return
+			}
+		}
+	}()
+
+	socket := <-sockets
+	time.Sleep(10 * time.Millisecond)
+	socket.Disconnect(false)
+	for i := 0; i <= n; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrSocketDisconnected) {
+				t.Errorf("got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d emits pending", n+1-i)
+		}
+	}
+
+	if _, err := socket.EmitWithAck(context.Background(), "ping"); !errors.Is(err, ErrSocketDisconnected) {
+		t.Errorf("got %v after disconnection", err)
+	}
+}
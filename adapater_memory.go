@@ -1,7 +1,9 @@
 package socketigo
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -13,93 +15,286 @@ type InMemoryAdapter struct {
 
 	nsp *Namespace
 
-	Sids  map[string]map[string]struct{} // Map<SocketId, Set<Room>>
-	Rooms map[string]map[string]struct{} // Map<Room, Set<SocketId>>
+	sids  map[string]map[string]struct{} // Map<SocketId, Set<Room>>
+	rooms map[string]map[string]struct{} // Map<Room, Set<SocketId>>
+
+	// Connection state recovery
+	sessionLock sync.Mutex
+	sessions    map[string]*persistedSession
+	packets     []*persistedPacket
+	offset      uint64
+	nextPurge   time.Time
 
 	logger *zap.SugaredLogger
 }
 
 func NewInMemoryAdapterIniter() AdapterIniter {
 	return func(nsp *Namespace) Adapter {
-		return &InMemoryAdapter{
-			nsp:    nsp,
-			Sids:   make(map[string]map[string]struct{}),
-			Rooms:  make(map[string]map[string]struct{}),
-			logger: nsp.logger.With("Adapter", "InMemory"),
-		}
+		return NewInMemoryAdapter(nsp)
+	}
+}
+
+// NewInMemoryAdapter creates the single-node adapter; distributed adapters
+// embed it to deliver packets to their local sockets.
+func NewInMemoryAdapter(nsp *Namespace) *InMemoryAdapter {
+	return &InMemoryAdapter{
+		nsp:      nsp,
+		sids:     make(map[string]map[string]struct{}),
+		rooms:    make(map[string]map[string]struct{}),
+		sessions: make(map[string]*persistedSession),
+		logger:   nsp.logger.With("Adapter", "InMemory"),
 	}
 }
 
 func (adp *InMemoryAdapter) Join(sid string, rooms ...string) {
 	adp.logger.Debugf("%s Join %v", sid, rooms)
 
-	if _, ok := adp.Sids[sid]; !ok {
-		adp.Sids[sid] = make(map[string]struct{})
+	adp.Lock()
+	defer adp.Unlock()
+
+	if _, ok := adp.sids[sid]; !ok {
+		adp.sids[sid] = make(map[string]struct{})
 	}
 
 	for _, room := range rooms {
-		adp.Sids[sid][room] = struct{}{}
+		adp.sids[sid][room] = struct{}{}
 
-		if _, ok := adp.Rooms[room]; !ok {
-			adp.Rooms[room] = make(map[string]struct{})
+		if _, ok := adp.rooms[room]; !ok {
+			adp.rooms[room] = make(map[string]struct{})
 		}
-		adp.Rooms[room][sid] = struct{}{}
+		adp.rooms[room][sid] = struct{}{}
 	}
 }
 
 func (adp *InMemoryAdapter) Leave(sid string, rooms ...string) {
 	adp.logger.Debugf("%s Leave %v", sid, rooms)
 
+	adp.Lock()
+	defer adp.Unlock()
+
 	for _, room := range rooms {
-		delete(adp.Sids[sid], room)
-		delete(adp.Rooms[room], sid)
+		delete(adp.sids[sid], room)
+		adp.leaveRoom(room, sid)
 	}
 }
 
 func (adp *InMemoryAdapter) LeaveAll(sid string) {
 	adp.logger.Debugf("%s LeaveAll", sid)
 
-	for room := range adp.Sids[sid] {
-		delete(adp.Rooms[room], sid)
+	adp.Lock()
+	defer adp.Unlock()
+
+	for room := range adp.sids[sid] {
+		adp.leaveRoom(room, sid)
 	}
-	delete(adp.Sids, sid)
+	delete(adp.sids, sid)
 }
 
-func (adp *InMemoryAdapter) Broadcast(packet *Packet, opts *BroadcastOptions) {
-	adp.logger.Debugf("Broadcast %v with opts %v", packet, opts)
+func (adp *InMemoryAdapter) leaveRoom(room, sid string) {
+	if _, ok := adp.rooms[room]; !ok {
+		return
+	}
+	delete(adp.rooms[room], sid)
+	if len(adp.rooms[room]) == 0 {
+		delete(adp.rooms, room)
+	}
+}
 
+func (adp *InMemoryAdapter) Sockets(rooms []string) map[string]struct{} {
 	adp.RLock()
 	defer adp.RUnlock()
 
+	sids := make(map[string]struct{})
+	if len(rooms) == 0 {
+		for sid := range adp.sids {
+			sids[sid] = struct{}{}
+		}
+		return sids
+	}
+
+	for _, room := range rooms {
+		for sid := range adp.rooms[room] {
+			sids[sid] = struct{}{}
+		}
+	}
+	return sids
+}
+
+// AllRooms returns the rooms with at least one socket, this server being the
+// only one.
+func (adp *InMemoryAdapter) AllRooms() (map[string]struct{}, error) {
+	adp.RLock()
+	defer adp.RUnlock()
+
+	rooms := make(map[string]struct{}, len(adp.rooms))
+	for room := range adp.rooms {
+		rooms[room] = struct{}{}
+	}
+	return rooms, nil
+}
+
+func (adp *InMemoryAdapter) SocketRooms(sid string) map[string]struct{} {
+	adp.RLock()
+	defer adp.RUnlock()
+
+	rooms := make(map[string]struct{}, len(adp.sids[sid]))
+	for room := range adp.sids[sid] {
+		rooms[room] = struct{}{}
+	}
+	return rooms
+}
+
+func (adp *InMemoryAdapter) Broadcast(packet *Packet, opts *BroadcastOptions) {
+	adp.logger.Debugf("Broadcast %v with opts %v", packet, opts)
+
+	if adp.nsp.server.recoveryDuration > 0 && packet.Type == PacketEvent && packet.Id == nil {
+		adp.persistPacket(packet, opts)
+	}
+
 	msgs, err := adp.nsp.parser.Encode(packet)
 	if err != nil {
 		adp.logger.Errorf("Broadcast packet %v: %v", packet, err)
+		return
 	}
 
-	sids := make(map[string]interface{})
+	for _, socket := range adp.sockets(opts) {
+		socket.notifyOutgoing(packet)
+		if err := socket.conn.WriteToEngine(msgs); err != nil {
+			adp.logger.Errorf("Broadcast sid=%v WriteToEngine: %v", socket.Id, err)
+		}
+	}
+}
 
+func (adp *InMemoryAdapter) BroadcastWithAck(packet *Packet, opts *BroadcastOptions, clientCount func(n int), ack func(sid string, resp *AckResponse)) int {
+	adp.logger.Debugf("BroadcastWithAck %v with opts %v", packet, opts)
+
+	// The packet may come from another server, so its id is replaced by one
+	// unique among the acks pending on the local sockets.
+	id := adp.nsp.nextAckId()
+	local := *packet
+	local.Id = &id
+	packet = &local
+
+	msgs, err := adp.nsp.parser.Encode(packet)
+	if err != nil {
+		adp.logger.Errorf("BroadcastWithAck packet %v: %v", packet, err)
+		clientCount(0)
+		return 1
+	}
+
+	// Only the sockets still connected once their ack is registered are
+	// counted, the others would never answer.
+	var sockets []*Socket
+	for _, socket := range adp.sockets(opts) {
+		socket := socket
+
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if opts.Timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		}
+		if !socket.registerAck(ctx, id, func(resp *AckResponse, err error) {
+			cancel()
+			if err != nil {
+				return
+			}
+			ack(socket.Id, resp)
+		}) {
+			cancel()
+			continue
+		}
+		sockets = append(sockets, socket)
+	}
+	clientCount(len(sockets))
+
+	for _, socket := range sockets {
+		socket.notifyOutgoing(packet)
+		if err := socket.conn.WriteToEngine(msgs); err != nil {
+			adp.logger.Errorf("BroadcastWithAck sid=%v WriteToEngine: %v", socket.Id, err)
+		}
+	}
+
+	return 1
+}
+
+func (adp *InMemoryAdapter) FetchSockets(opts *BroadcastOptions) ([]*RemoteSocket, error) {
+	sockets := adp.sockets(opts)
+
+	remotes := make([]*RemoteSocket, 0, len(sockets))
+	for _, socket := range sockets {
+		remotes = append(remotes, newRemoteSocket(socket))
+	}
+	return remotes, nil
+}
+
+func (adp *InMemoryAdapter) AddSockets(opts *BroadcastOptions, rooms []string) {
+	for _, socket := range adp.sockets(opts) {
+		socket.Join(rooms...)
+	}
+}
+
+func (adp *InMemoryAdapter) DelSockets(opts *BroadcastOptions, rooms []string) {
+	for _, socket := range adp.sockets(opts) {
+		socket.Leave(rooms...)
+	}
+}
+
+func (adp *InMemoryAdapter) DisconnectSockets(opts *BroadcastOptions, close bool) {
+	for _, socket := range adp.sockets(opts) {
+		socket.Disconnect(close)
+	}
+}
+
+func (adp *InMemoryAdapter) ServerCount() int {
+	return 1
+}
+
+func (adp *InMemoryAdapter) ServerSideEmit(packet []interface{}) {
+	adp.logger.Warn("This adapter does not support the ServerSideEmit() functionality")
+}
+
+func (adp *InMemoryAdapter) ServerSideEmitWithAck(ctx context.Context, packet []interface{}) ([]*AckResponse, error) {
+	adp.logger.Warn("This adapter does not support the ServerSideEmit() functionality")
+	return nil, nil
+}
+
+// sockets returns the local sockets matching the broadcast options.
+func (adp *InMemoryAdapter) sockets(opts *BroadcastOptions) []*Socket {
+	adp.RLock()
+	excludes := make(map[string]struct{})
+	for room := range opts.Excludes {
+		for sid := range adp.rooms[room] {
+			excludes[sid] = struct{}{}
+		}
+	}
+
+	sids := make(map[string]struct{})
 	if opts.IncludeAll {
-		for sid := range adp.Sids {
-			if _, ok := opts.Excludes[sid]; ok {
+		for sid := range adp.sids {
+			if _, ok := excludes[sid]; ok {
 				continue
 			}
 			sids[sid] = struct{}{}
 		}
 	} else {
 		for _, room := range opts.Includes {
-			for sid := range adp.Rooms[room] {
-				if _, ok := opts.Excludes[sid]; ok {
+			for sid := range adp.rooms[room] {
+				if _, ok := excludes[sid]; ok {
 					continue
 				}
 				sids[sid] = struct{}{}
 			}
 		}
 	}
+	adp.RUnlock()
 
+	adp.nsp.RLock()
+	defer adp.nsp.RUnlock()
+
+	sockets := make([]*Socket, 0, len(sids))
 	for sid := range sids {
-		if err := adp.nsp.sockets[sid].conn.WriteToEngine(msgs); err != nil {
-			adp.logger.Errorf("Broadcast sid=%v WriteToEngine: %v", sid, err)
+		if socket, ok := adp.nsp.sockets[sid]; ok {
+			sockets = append(sockets, socket)
 		}
 	}
-
+	return sockets
 }
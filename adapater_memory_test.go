@@ -0,0 +1,42 @@
+package socketigo
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Acks are matched by an id allocated by the namespace, whatever id the
+// packet carries.
+func TestBroadcastWithAckIds(t *testing.T) {
+	srv, url := newTestServer(t)
+	c := connect(t, url, "/")
+	nsp := srv.Of("/")
+
+	callerId := 1
+	for i, id := range []*int{nil, &callerId, &callerId} {
+		acks := make(chan string, 1)
+		packet := &Packet{Type: PacketEvent, Namespace: "/", Data: []interface{}{"ping"}, Id: id}
+		nsp.adapter.BroadcastWithAck(packet, &BroadcastOptions{IncludeAll: true}, func(n int) {}, func(sid string, resp *AckResponse) {
+			var s string
+			resp.Decode(&s)
+			acks <- s
+		})
+
+		want := strconv.Itoa(i*2 + 1)
+		if got, err := c.Expect(time.Second); err != nil || got != "2"+want+`["ping"]` {
+			t.Fatalf("got %q, %v", got, err)
+		}
+		if err := c.Send("3" + want + `["pong` + want + `"]`); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case ack := <-acks:
+			if ack != "pong"+want {
+				t.Errorf("got %s", ack)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no ack")
+		}
+	}
+}
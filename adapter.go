@@ -1,15 +1,72 @@
 package socketigo
 
+import (
+	"context"
+	"time"
+)
+
 type Adapter interface {
 	Join(sid string, rooms ...string)
 	Leave(sid string, rooms ...string)
 	LeaveAll(sid string)
 
+	// Sockets returns the ids of the sockets of this server in any of the
+	// given rooms, or of every socket when no room is given. FetchSockets
+	// returns those of every server.
+	Sockets(rooms []string) map[string]struct{}
+	// SocketRooms returns the rooms a socket of this server has joined, its
+	// own id included.
+	SocketRooms(sid string) map[string]struct{}
+	// AllRooms returns the rooms with at least one socket on any server.
+	AllRooms() (map[string]struct{}, error)
+
 	Broadcast(packet *Packet, opts *BroadcastOptions)
+	// BroadcastWithAck sends a packet carrying an ack id and returns the
+	// number of servers it was sent to, this one included. clientCount is
+	// called once per such server with the number of targeted sockets there,
+	// and ack once per received acknowledgement.
+	BroadcastWithAck(packet *Packet, opts *BroadcastOptions, clientCount func(n int), ack func(sid string, resp *AckResponse)) int
+
+	// FetchSockets returns the sockets matching the options, on every server.
+	FetchSockets(opts *BroadcastOptions) ([]*RemoteSocket, error)
+	// AddSockets makes the matching sockets join the rooms.
+	AddSockets(opts *BroadcastOptions, rooms []string)
+	// DelSockets makes the matching sockets leave the rooms.
+	DelSockets(opts *BroadcastOptions, rooms []string)
+	DisconnectSockets(opts *BroadcastOptions, close bool)
+
+	// ServerCount returns the number of servers sharing this adapter.
+	ServerCount() int
+
+	// ServerSideEmit sends the packet, an event name followed by its
+	// arguments, to the other servers.
+	ServerSideEmit(packet []interface{})
+	// ServerSideEmitWithAck sends the packet to the other servers and collects
+	// one acknowledgement from each, until ctx expires.
+	ServerSideEmitWithAck(ctx context.Context, packet []interface{}) ([]*AckResponse, error)
+
+	// PersistSession keeps the state of a socket which lost its connection.
+	PersistSession(session *Session)
+	// RestoreSession returns the persisted session along with the packets
+	// broadcast to it after offset, or nil when it cannot be recovered.
+	RestoreSession(pid, offset string) (*Session, error)
+}
+
+// A DistributedAdapter shares rooms and broadcasts across servers but keeps
+// the sessions and event offsets of the in-memory adapter, which are local to
+// each server. WithConnectionStateRecovery cannot be used with it.
+type DistributedAdapter interface {
+	Adapter
+	Distributed()
 }
 
 type BroadcastOptions struct {
 	IncludeAll bool
 	Includes   []string
-	Excludes   map[string]struct{}
+	Excludes   map[string]struct{} // Rooms whose sockets are skipped
+
+	// Timeout bounds how long each socket waits for an acknowledgement.
+	Timeout time.Duration
+	// Local restricts the broadcast to the sockets of this server.
+	Local bool
 }
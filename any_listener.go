@@ -0,0 +1,121 @@
+package socketigo
+
+import "sync"
+
+// AnyListener is called with the name and the arguments of every event,
+// whatever its name. For incoming events expecting an acknowledgement, the
+// last argument is the ack function passed to the handler.
+type AnyListener func(event string, args ...interface{})
+
+type anyListeners struct {
+	lock      sync.RWMutex
+	listeners []*Listener
+}
+
+func (l *anyListeners) add(f AnyListener, prepend bool) *Listener {
+	listener := &Listener{any: f}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if prepend {
+		l.listeners = append([]*Listener{listener}, l.listeners...)
+	} else {
+		l.listeners = append(l.listeners, listener)
+	}
+	return listener
+}
+
+// remove drops the given listeners, or all of them when none is given.
+func (l *anyListeners) remove(ls []*Listener) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if len(ls) == 0 {
+		l.listeners = nil
+		return
+	}
+
+	listeners := l.listeners[:0:0]
+	for _, listener := range l.listeners {
+		removed := false
+		for _, r := range ls {
+			if listener == r {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			listeners = append(listeners, listener)
+		}
+	}
+	l.listeners = listeners
+}
+
+func (l *anyListeners) len() int {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return len(l.listeners)
+}
+
+func (l *anyListeners) call(event string, args []interface{}) {
+	l.lock.RLock()
+	listeners := l.listeners
+	l.lock.RUnlock()
+
+	for _, listener := range listeners {
+		listener.any(event, args...)
+	}
+}
+
+// OnAny registers a listener called for every incoming event, before its
+// handler. The returned Listener removes it with OffAny.
+func (s *Socket) OnAny(f AnyListener) *Listener {
+	return s.anyIncoming.add(f, false)
+}
+
+// PrependAny is like OnAny, but the listener is called before the ones
+// already registered.
+func (s *Socket) PrependAny(f AnyListener) *Listener {
+	return s.anyIncoming.add(f, true)
+}
+
+// OffAny removes the given catch-all listeners, or all of them when called
+// without arguments.
+func (s *Socket) OffAny(ls ...*Listener) {
+	s.anyIncoming.remove(ls)
+}
+
+// OnAnyOutgoing registers a listener called for every event sent to the
+// socket, including broadcasts. The returned Listener removes it with
+// OffAnyOutgoing.
+func (s *Socket) OnAnyOutgoing(f AnyListener) *Listener {
+	return s.anyOutgoing.add(f, false)
+}
+
+// PrependAnyOutgoing is like OnAnyOutgoing, but the listener is called
+// before the ones already registered.
+func (s *Socket) PrependAnyOutgoing(f AnyListener) *Listener {
+	return s.anyOutgoing.add(f, true)
+}
+
+// OffAnyOutgoing removes the given outgoing listeners, or all of them when
+// called without arguments.
+func (s *Socket) OffAnyOutgoing(ls ...*Listener) {
+	s.anyOutgoing.remove(ls)
+}
+
+func (s *Socket) notifyOutgoing(packet *Packet) {
+	if packet.Type != PacketEvent && packet.Type != PacketBinaryEvent {
+		return
+	}
+	data, ok := packet.Data.([]interface{})
+	if !ok || len(data) == 0 {
+		return
+	}
+	name, ok := data[0].(string)
+	if !ok {
+		return
+	}
+	s.anyOutgoing.call(name, data[1:])
+}
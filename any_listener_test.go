@@ -0,0 +1,32 @@
+package socketigo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOffAnyByHandle(t *testing.T) {
+	var calls []string
+	listener := func(name string) AnyListener {
+		return func(event string, args ...interface{}) {
+			calls = append(calls, name)
+		}
+	}
+
+	var l anyListeners
+	a := l.add(listener("a"), false)
+	l.add(listener("b"), false)
+	l.add(listener("c"), true)
+
+	// a and b share their code, only the handle tells them apart.
+	l.remove([]*Listener{a})
+	l.call("event", nil)
+	if want := []string{"c", "b"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("got %v, want %v", calls, want)
+	}
+
+	l.remove(nil)
+	if l.len() != 0 {
+		t.Errorf("got %d listeners after removing all", l.len())
+	}
+}
@@ -0,0 +1,265 @@
+package socketigo
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+type binaryPlaceholder struct {
+	Placeholder bool `json:"_placeholder"`
+	Num         int  `json:"num"`
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// deconstruct encodes data to JSON with every non-empty []byte replaced by a
+// placeholder, returning the bytes as attachments. The binary leaves are
+// found in a single walk, which swaps them for markers in a copy of the
+// branches leading to them; encoding/json then encodes the copy by its own
+// rules, and the markers are replaced in the output. Data without binary is
+// returned untouched.
+//
+// []byte values behind custom marshalers or in unexported embedded structs
+// are left to encoding/json.
+func deconstruct(data interface{}) (interface{}, [][]byte, error) {
+	b := &binaryWalker{}
+	v, found := b.walk(reflect.ValueOf(data))
+	if !found {
+		return data, nil, nil
+	}
+
+	bs, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Leaves encoding/json skips, e.g. tagged "-", are not attached.
+	var (
+		buffer      bytes.Buffer
+		attachments [][]byte
+		prefix      = []byte(`"` + b.prefix)
+	)
+	for {
+		i := bytes.Index(bs, prefix)
+		if i < 0 {
+			buffer.Write(bs)
+			break
+		}
+		buffer.Write(bs[:i])
+		bs = bs[i+len(prefix):]
+
+		var idx [markerIndexLen]byte
+		if len(bs) <= markerIndexB64Len || bs[markerIndexB64Len] != '"' {
+			return nil, nil, errors.New("truncated binary marker")
+		}
+		if _, err := base64.StdEncoding.Decode(idx[:], bs[:markerIndexB64Len]); err != nil {
+			return nil, nil, err
+		}
+		bs = bs[markerIndexB64Len+1:]
+		n := binary.BigEndian.Uint32(idx[markerIndexLen-4:])
+		if int(n) >= len(b.leaves) {
+			return nil, nil, fmt.Errorf("illegal binary marker %d", n)
+		}
+
+		placeholder, err := json.Marshal(&binaryPlaceholder{Placeholder: true, Num: len(attachments)})
+		if err != nil {
+			return nil, nil, err
+		}
+		buffer.Write(placeholder)
+		attachments = append(attachments, b.leaves[n])
+	}
+	if len(attachments) == 0 {
+		return data, nil, nil
+	}
+	return json.RawMessage(buffer.Bytes()), attachments, nil
+}
+
+// A marker is a random nonce, the same for every leaf of a payload, followed
+// by the index of the leaf. Both parts are multiples of 3 bytes so that their
+// base64 encodings can be told apart.
+const (
+	markerNonceLen    = 12
+	markerIndexLen    = 6
+	markerIndexB64Len = markerIndexLen / 3 * 4
+)
+
+type binaryWalker struct {
+	nonce  []byte
+	prefix string // Base64 of the nonce
+	leaves [][]byte
+}
+
+// opaque reports whether encoding/json encodes values of type t by a method
+// of theirs.
+func opaque(t reflect.Type) bool {
+	return t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) ||
+		reflect.PointerTo(t).Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType)
+}
+
+// walk returns a copy of v with its binary leaves replaced by markers, and
+// whether there were any. Without binary, v itself is returned.
+func (b *binaryWalker) walk(v reflect.Value) (reflect.Value, bool) {
+	if !v.IsValid() || opaque(v.Type()) {
+		return v, false
+	}
+
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Len() == 0 {
+				return v, false
+			}
+			return reflect.ValueOf(b.marker(v.Bytes())).Convert(v.Type()), true
+		}
+		if v.IsNil() {
+			return v, false
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		if !b.walkElems(v, out) {
+			return v, false
+		}
+		return out, true
+	case reflect.Array:
+		out := reflect.New(v.Type()).Elem()
+		if !b.walkElems(v, out) {
+			return v, false
+		}
+		return out, true
+	case reflect.Interface:
+		if v.IsNil() {
+			return v, false
+		}
+		return b.walk(v.Elem())
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v, false
+		}
+		elem, found := b.walk(v.Elem())
+		if !found {
+			return v, false
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(elem)
+		return out, true
+	case reflect.Map:
+		if v.IsNil() {
+			return v, false
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		found := false
+		iter := v.MapRange()
+		for iter.Next() {
+			elem, ok := b.walk(iter.Value())
+			found = found || ok
+			out.SetMapIndex(iter.Key(), elem)
+		}
+		if !found {
+			return v, false
+		}
+		return out, true
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if field, ok := b.walk(v.Field(i)); ok {
+				out.Field(i).Set(field)
+				found = true
+			}
+		}
+		if !found {
+			return v, false
+		}
+		return out, true
+	}
+	return v, false
+}
+
+// walkElems walks the elements of a slice or an array into out, which has
+// the same type and length.
+func (b *binaryWalker) walkElems(v, out reflect.Value) bool {
+	found := false
+	for i := 0; i < v.Len(); i++ {
+		elem, ok := b.walk(v.Index(i))
+		found = found || ok
+		out.Index(i).Set(elem)
+	}
+	return found
+}
+
+// marker records a binary leaf, returning the bytes to stand for it.
+func (b *binaryWalker) marker(bs []byte) []byte {
+	if b.nonce == nil {
+		b.nonce = make([]byte, markerNonceLen)
+		if _, err := rand.Read(b.nonce); err != nil {
+			panic(err)
+		}
+		b.prefix = base64.StdEncoding.EncodeToString(b.nonce)
+	}
+
+	marker := make([]byte, markerNonceLen+markerIndexLen)
+	copy(marker, b.nonce)
+	binary.BigEndian.PutUint32(marker[len(marker)-4:], uint32(len(b.leaves)))
+	b.leaves = append(b.leaves, bs)
+	return marker
+}
+
+// reconstruct replaces the placeholders of a decoded payload with buffers.
+func reconstruct(data interface{}, buffers [][]byte) (interface{}, error) {
+	switch d := data.(type) {
+	case []interface{}:
+		for i := range d {
+			v, err := reconstruct(d[i], buffers)
+			if err != nil {
+				return nil, err
+			}
+			d[i] = v
+		}
+	case map[string]interface{}:
+		if placeholder, _ := d["_placeholder"].(bool); placeholder {
+			num, err := placeholderNum(d["num"])
+			if err != nil {
+				return nil, err
+			}
+			if num < 0 || num >= len(buffers) {
+				return nil, fmt.Errorf("illegal attachment %d", num)
+			}
+			return buffers[num], nil
+		}
+		for k := range d {
+			v, err := reconstruct(d[k], buffers)
+			if err != nil {
+				return nil, err
+			}
+			d[k] = v
+		}
+	}
+	return data, nil
+}
+
+func placeholderNum(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		return int(i), err
+	case float64:
+		return int(n), nil
+	case int:
+		return n, nil
+	default:
+		return 0, fmt.Errorf("invalid placeholder num %v", v)
+	}
+}
@@ -0,0 +1,247 @@
+package socketigo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/taogames/engine.igo/message"
+)
+
+type upload struct {
+	Name    string          `json:"name"`
+	Body    []byte          `json:"body"`
+	Thumb   []byte          `json:"thumb,omitempty"`
+	Skipped []byte          `json:"-"`
+	Raw     json.RawMessage `json:"raw,omitempty"`
+	Meta
+	secret []byte
+}
+
+type Meta struct {
+	Size int `json:"size"`
+}
+
+// placeholders decodes a deconstructed payload, replacing the placeholders
+// by the attachments so that it can be compared.
+func placeholders(t *testing.T, payload interface{}, attachments [][]byte) interface{} {
+	t.Helper()
+	bs, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data interface{}
+	if err := json.Unmarshal(bs, &data); err != nil {
+		t.Fatal(err)
+	}
+	data, err = reconstruct(data, attachments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDeconstruct(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        interface{}
+		attachments int
+		want        interface{}
+	}{
+		{
+			name:        "top level",
+			data:        []interface{}{"a", []byte{1}},
+			attachments: 1,
+			want:        []interface{}{"a", []byte{1}},
+		},
+		{
+			name:        "nested slices and maps",
+			data:        []interface{}{[][]byte{{1}, {2}}, map[string]interface{}{"file": []byte{3}, "n": 1}},
+			attachments: 3,
+			want: []interface{}{
+				[]interface{}{[]byte{1}, []byte{2}},
+				map[string]interface{}{"file": []byte{3}, "n": float64(1)},
+			},
+		},
+		{
+			name:        "struct",
+			data:        []interface{}{&upload{Name: "a", Body: []byte{1}, Skipped: []byte{2}, Raw: json.RawMessage(`"x"`), Meta: Meta{Size: 1}, secret: []byte{3}}},
+			attachments: 1,
+			want: []interface{}{
+				map[string]interface{}{"name": "a", "body": []byte{1}, "raw": "x", "size": float64(1)},
+			},
+		},
+		{
+			name:        "map with integer keys",
+			data:        []interface{}{map[int][]byte{1: {1}}},
+			attachments: 1,
+			want:        []interface{}{map[string]interface{}{"1": []byte{1}}},
+		},
+		{
+			name:        "array",
+			data:        []interface{}{[2][]byte{{1}, nil}},
+			attachments: 1,
+			want:        []interface{}{[]interface{}{[]byte{1}, nil}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, attachments, err := deconstruct(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(attachments) != tt.attachments {
+				t.Fatalf("got %d attachments, want %d", len(attachments), tt.attachments)
+			}
+			if got := placeholders(t, payload, attachments); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeconstructWithoutBinary(t *testing.T) {
+	data := []interface{}{"a", map[string]interface{}{"b": []int{1}}, &Meta{Size: 1}, json.RawMessage(`[1]`), []byte{}}
+	payload, attachments, err := deconstruct(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 0 {
+		t.Errorf("got %d attachments", len(attachments))
+	}
+	if !reflect.DeepEqual(payload, data) {
+		t.Errorf("got %#v, want the data untouched", payload)
+	}
+}
+
+func TestDeconstructKeepsData(t *testing.T) {
+	body := []byte{1, 2}
+	nested := []interface{}{body}
+	data := []interface{}{"a", nested, &upload{Body: body}}
+	if _, _, err := deconstruct(data); err != nil {
+		t.Fatal(err)
+	}
+	if nested[0].([]byte)[0] != 1 || data[2].(*upload).Body[0] != 1 {
+		t.Error("deconstruct modified the data")
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	packet := &Packet{
+		Type:      PacketEvent,
+		Namespace: "/files",
+		Data: []interface{}{"upload", &upload{
+			Name:  "a.png",
+			Body:  []byte{0xde, 0xad},
+			Thumb: []byte{0xbe, 0xef},
+		}},
+		Id: intPtr(42),
+	}
+
+	msgs, err := DefaultParser.Encode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages, want 3", len(msgs))
+	}
+	if !bytes.HasPrefix(msgs[0].Data, []byte("52-/files,42[")) {
+		t.Errorf("unexpected header %s", msgs[0].Data)
+	}
+
+	dec := DefaultParser.NewDecoder()
+	var got *Packet
+	for i, msg := range msgs {
+		p, err := dec.Decode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < len(msgs)-1 && p != nil {
+			t.Fatalf("got a packet before the last attachment: %+v", p)
+		}
+		got = p
+	}
+	if got == nil {
+		t.Fatal("no packet decoded")
+	}
+	if got.Type != PacketBinaryEvent || got.Namespace != "/files" || *got.Id != 42 {
+		t.Errorf("got %+v", got)
+	}
+
+	args, err := DefaultParser.ParseEventArgs(got, []reflect.Type{reflect.TypeOf(upload{})}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := args[0].Interface().(upload)
+	if u.Name != "a.png" || !bytes.Equal(u.Body, []byte{0xde, 0xad}) || !bytes.Equal(u.Thumb, []byte{0xbe, 0xef}) {
+		t.Errorf("got %+v", u)
+	}
+
+	// The attachments of a binary message are buffered until complete.
+	if _, err := dec.Decode(&message.Message{Type: message.MTBinary, Data: []byte{1}}); err == nil {
+		t.Error("expected an error for an unexpected attachment")
+	}
+}
+
+// Connections buffering binary events at the same time each reconstruct their
+// own attachments.
+func TestInterleavedAttachments(t *testing.T) {
+	const n = 8
+
+	srv, url := newTestServer(t)
+	type received struct {
+		sid  string
+		name string
+		data []byte
+	}
+	uploads := make(chan received, 2*n)
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		socket.On("upload", func(name string, data []byte) {
+			uploads <- received{socket.Id, name, data}
+		})
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		a, b := connect(t, url, "/"), connect(t, url, "/")
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			steps := []func() error{
+				func() error { return a.Send(fmt.Sprintf(`51-["upload","a%d",{"_placeholder":true,"num":0}]`, i)) },
+				func() error { return b.Send(fmt.Sprintf(`51-["upload","b%d",{"_placeholder":true,"num":0}]`, i)) },
+				func() error { return a.SendBinary([]byte(fmt.Sprintf("a%d", i))) },
+				func() error { return b.SendBinary([]byte(fmt.Sprintf("b%d", i))) },
+			}
+			for _, step := range steps {
+				if err := step(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	sids := make(map[string]string)
+	for i := 0; i < 2*n; i++ {
+		select {
+		case u := <-uploads:
+			if string(u.data) != u.name {
+				t.Errorf("%s got attachment %q", u.name, u.data)
+			}
+			if name, ok := sids[u.sid]; ok {
+				t.Errorf("socket %s got %s and %s", u.sid, name, u.name)
+			}
+			sids[u.sid] = u.name
+		case <-time.After(time.Second):
+			t.Fatalf("%d uploads received, want %d", i, 2*n)
+		}
+	}
+}
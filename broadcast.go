@@ -1,11 +1,54 @@
 package socketigo
 
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
 type Broadcast struct {
 	nsp *Namespace
 
 	includeAll bool
 	includes   []string
 	excludes   map[string]struct{}
+
+	timeout time.Duration
+}
+
+// To returns a broadcast restricted to the given rooms, in addition to the
+// rooms already targeted.
+func (b *Broadcast) To(rooms ...string) *Broadcast {
+	nb := b.clone()
+	nb.includeAll = false
+	nb.includes = append(nb.includes, rooms...)
+	return nb
+}
+
+// In is an alias of To.
+func (b *Broadcast) In(rooms ...string) *Broadcast {
+	return b.To(rooms...)
+}
+
+// Except returns a broadcast skipping the sockets in any of the given rooms.
+// Socket ids can be used as rooms to skip single sockets.
+func (b *Broadcast) Except(rooms ...string) *Broadcast {
+	nb := b.clone()
+	for _, room := range rooms {
+		nb.excludes[room] = struct{}{}
+	}
+	return nb
+}
+
+func (b *Broadcast) clone() *Broadcast {
+	nb := *b
+	nb.includes = append([]string(nil), b.includes...)
+	nb.excludes = make(map[string]struct{}, len(b.excludes))
+	for room := range b.excludes {
+		nb.excludes[room] = struct{}{}
+	}
+	return &nb
 }
 
 func (b *Broadcast) Emit(eName string, args ...interface{}) {
@@ -16,9 +59,137 @@ func (b *Broadcast) Emit(eName string, args ...interface{}) {
 		Data:      data,
 	}
 
-	b.nsp.adapter.Broadcast(packet, &BroadcastOptions{
+	b.nsp.adapter.Broadcast(packet, b.options())
+}
+
+// DefaultAckTimeout bounds Broadcast.EmitWithAck when neither Timeout nor
+// the deadline of its context does, so that the acknowledgements of sockets
+// which never answer are not kept forever.
+const DefaultAckTimeout = 10 * time.Second
+
+// Timeout sets how long EmitWithAck waits for the acknowledgements.
+func (b *Broadcast) Timeout(d time.Duration) *Broadcast {
+	nb := b.clone()
+	nb.timeout = d
+	return nb
+}
+
+// EmitWithAck emits an event to every targeted socket and collects their
+// acknowledgements, keyed by socket id. When the timeout or the deadline of
+// ctx expires before every socket answered, the responses received so far
+// are returned along with an error wrapping ErrAckTimeout; when ctx is
+// cancelled, the error wraps ctx.Err() instead. Without a timeout nor a
+// deadline, DefaultAckTimeout applies.
+func (b *Broadcast) EmitWithAck(ctx context.Context, eName string, args ...interface{}) (map[string]*AckResponse, error) {
+	timeout := b.timeout
+	if _, ok := ctx.Deadline(); !ok && timeout <= 0 {
+		timeout = DefaultAckTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	id := b.nsp.nextAckId()
+	data := append([]interface{}{eName}, args...)
+	packet := &Packet{
+		Type:      PacketEvent,
+		Namespace: b.nsp.Name(),
+		Data:      data,
+		Id:        &id,
+	}
+
+	opts := b.options()
+	if deadline, ok := ctx.Deadline(); ok {
+		opts.Timeout = time.Until(deadline)
+	}
+
+	var (
+		lock            sync.Mutex
+		responses       = make(map[string]*AckResponse)
+		expectedServers = 0 // Decremented by the reports arriving before the count
+		counted         bool
+		expectedClients = 0
+		done            = make(chan struct{})
+		closed          bool
+	)
+	check := func() {
+		if counted && !closed && expectedServers == 0 && len(responses) >= expectedClients {
+			closed = true
+			close(done)
+		}
+	}
+
+	servers := b.nsp.adapter.BroadcastWithAck(packet, opts, func(n int) {
+		lock.Lock()
+		defer lock.Unlock()
+		expectedServers--
+		expectedClients += n
+		check()
+	}, func(sid string, resp *AckResponse) {
+		lock.Lock()
+		defer lock.Unlock()
+		responses[sid] = resp
+		check()
+	})
+
+	lock.Lock()
+	expectedServers += servers
+	counted = true
+	check()
+	lock.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	result := make(map[string]*AckResponse, len(responses))
+	for sid, resp := range responses {
+		result[sid] = resp
+	}
+	if !closed {
+		if expectedServers > 0 {
+			return result, fmt.Errorf("%w: %d server(s) did not report", ackError(ctx), expectedServers)
+		}
+		return result, fmt.Errorf("%w: received %d of %d acknowledgements", ackError(ctx), len(result), expectedClients)
+	}
+	return result, nil
+}
+
+// FetchSockets returns the targeted sockets, wherever they are connected.
+func (b *Broadcast) FetchSockets() ([]*RemoteSocket, error) {
+	sockets, err := b.nsp.adapter.FetchSockets(b.options())
+	for _, socket := range sockets {
+		socket.nsp = b.nsp
+	}
+	return sockets, err
+}
+
+// SocketsJoin makes the targeted sockets join the rooms.
+func (b *Broadcast) SocketsJoin(rooms ...string) {
+	b.nsp.adapter.AddSockets(b.options(), rooms)
+}
+
+// SocketsLeave makes the targeted sockets leave the rooms.
+func (b *Broadcast) SocketsLeave(rooms ...string) {
+	b.nsp.adapter.DelSockets(b.options(), rooms)
+}
+
+// DisconnectSockets disconnects the targeted sockets, closing their
+// underlying connections when closeConn is set.
+func (b *Broadcast) DisconnectSockets(closeConn bool) {
+	b.nsp.adapter.DisconnectSockets(b.options(), closeConn)
+}
+
+func (b *Broadcast) options() *BroadcastOptions {
+	return &BroadcastOptions{
 		IncludeAll: b.includeAll,
 		Includes:   b.includes,
 		Excludes:   b.excludes,
-	})
+	}
 }
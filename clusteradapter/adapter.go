@@ -0,0 +1,485 @@
+package clusteradapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	socketigo "github.com/taogames/socket.igo"
+	"github.com/taogames/socket.igo/internal/wire"
+	"go.uber.org/zap"
+)
+
+var ErrRequestTimeout = errors.New("timeout reached while waiting for response")
+
+type Adapter struct {
+	*socketigo.InMemoryAdapter
+
+	node *Node
+	nsp  *socketigo.Namespace
+
+	logger *zap.SugaredLogger
+}
+
+// Distributed marks the adapter as a socketigo.DistributedAdapter, sessions
+// are not shared with the other servers.
+func (adp *Adapter) Distributed() {}
+
+type pendingRequest struct {
+	nsp       *socketigo.Namespace
+	pending   map[string]struct{} // Peers yet to respond
+	sockets   []*socketigo.RemoteSocket
+	rooms     map[string]struct{}
+	responses []*socketigo.AckResponse
+	done      chan struct{}
+}
+
+type ackRequest struct {
+	nsp         *socketigo.Namespace
+	pending     map[string]struct{} // Peers yet to report their client count
+	clientCount func(n int)
+	ack         func(sid string, resp *socketigo.AckResponse)
+}
+
+func (n *Node) newAdapter(nsp *socketigo.Namespace) *Adapter {
+	adp := &Adapter{
+		InMemoryAdapter: socketigo.NewInMemoryAdapter(nsp),
+		node:            n,
+		nsp:             nsp,
+		logger:          nsp.Logger().With("Adapter", "Cluster", "uid", n.uid),
+	}
+
+	n.lock.Lock()
+	n.adapters[nsp.Name()] = adp
+	n.lock.Unlock()
+
+	return adp
+}
+
+func (n *Node) adapter(name string) *Adapter {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.adapters[name]
+}
+
+func (adp *Adapter) Broadcast(packet *socketigo.Packet, opts *socketigo.BroadcastOptions) {
+	if raw, ok := wire.FromOptions(opts); ok && !opts.Local {
+		adp.node.broadcast(&message{
+			Type:   messageBroadcast,
+			Nsp:    adp.nsp.Name(),
+			Packet: wire.FromPacket(packet),
+			Opts:   raw,
+		})
+	}
+
+	adp.InMemoryAdapter.Broadcast(packet, opts)
+}
+
+func (adp *Adapter) BroadcastWithAck(packet *socketigo.Packet, opts *socketigo.BroadcastOptions, clientCount func(n int), ack func(sid string, resp *socketigo.AckResponse)) int {
+	servers := 1
+	if raw, ok := wire.FromOptions(opts); ok && !opts.Local {
+		requestId := wire.NewUid()
+		req := &ackRequest{
+			nsp:         adp.nsp,
+			pending:     make(map[string]struct{}),
+			clientCount: clientCount,
+			ack:         ack,
+		}
+
+		adp.node.lock.Lock()
+		peers := adp.node.peerList()
+		for _, p := range peers {
+			req.pending[p.uid] = struct{}{}
+		}
+		adp.node.ackRequests[requestId] = req
+		adp.node.lock.Unlock()
+		servers += len(peers)
+
+		adp.node.send(peers, &message{
+			Type:      messageBroadcastWithAck,
+			Nsp:       adp.nsp.Name(),
+			RequestId: requestId,
+			Packet:    wire.FromPacket(packet),
+			Opts:      raw,
+		})
+
+		timeout := opts.Timeout
+		if timeout <= 0 {
+			timeout = adp.node.requestsTimeout
+		}
+		time.AfterFunc(timeout, func() {
+			adp.node.lock.Lock()
+			delete(adp.node.ackRequests, requestId)
+			adp.node.lock.Unlock()
+		})
+	}
+
+	adp.InMemoryAdapter.BroadcastWithAck(packet, opts, clientCount, ack)
+	return servers
+}
+
+func (adp *Adapter) FetchSockets(opts *socketigo.BroadcastOptions) ([]*socketigo.RemoteSocket, error) {
+	local, err := adp.InMemoryAdapter.FetchSockets(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := wire.FromOptions(opts)
+	if !ok {
+		return local, nil
+	}
+
+	req := adp.newRequest()
+	req.sockets = local
+
+	msg := &message{
+		Type: messageFetchSockets,
+		Nsp:  adp.nsp.Name(),
+		Opts: raw,
+	}
+	if !adp.node.request(msg, req) {
+		return local, nil
+	}
+
+	timer := time.NewTimer(adp.node.requestsTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-req.done:
+		return req.sockets, nil
+	case <-timer.C:
+		adp.node.lock.Lock()
+		defer adp.node.lock.Unlock()
+		delete(adp.node.requests, msg.RequestId)
+		return req.sockets, fmt.Errorf("fetchSockets: %w", ErrRequestTimeout)
+	}
+}
+
+// AllRooms returns the rooms with at least one socket on any node.
+func (adp *Adapter) AllRooms() (map[string]struct{}, error) {
+	local, err := adp.InMemoryAdapter.AllRooms()
+	if err != nil {
+		return nil, err
+	}
+
+	req := adp.newRequest()
+	req.rooms = local
+
+	msg := &message{
+		Type: messageAllRooms,
+		Nsp:  adp.nsp.Name(),
+	}
+	if !adp.node.request(msg, req) {
+		return local, nil
+	}
+
+	timer := time.NewTimer(adp.node.requestsTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-req.done:
+		return req.rooms, nil
+	case <-timer.C:
+		adp.node.lock.Lock()
+		defer adp.node.lock.Unlock()
+		delete(adp.node.requests, msg.RequestId)
+		return req.rooms, fmt.Errorf("allRooms: %w", ErrRequestTimeout)
+	}
+}
+
+func (adp *Adapter) AddSockets(opts *socketigo.BroadcastOptions, rooms []string) {
+	if raw, ok := wire.FromOptions(opts); ok {
+		adp.node.broadcast(&message{
+			Type:  messageSocketsJoin,
+			Nsp:   adp.nsp.Name(),
+			Opts:  raw,
+			Rooms: rooms,
+		})
+	}
+	adp.InMemoryAdapter.AddSockets(opts, rooms)
+}
+
+func (adp *Adapter) DelSockets(opts *socketigo.BroadcastOptions, rooms []string) {
+	if raw, ok := wire.FromOptions(opts); ok {
+		adp.node.broadcast(&message{
+			Type:  messageSocketsLeave,
+			Nsp:   adp.nsp.Name(),
+			Opts:  raw,
+			Rooms: rooms,
+		})
+	}
+	adp.InMemoryAdapter.DelSockets(opts, rooms)
+}
+
+func (adp *Adapter) DisconnectSockets(opts *socketigo.BroadcastOptions, close bool) {
+	if raw, ok := wire.FromOptions(opts); ok {
+		adp.node.broadcast(&message{
+			Type:  messageDisconnectSockets,
+			Nsp:   adp.nsp.Name(),
+			Opts:  raw,
+			Close: close,
+		})
+	}
+	adp.InMemoryAdapter.DisconnectSockets(opts, close)
+}
+
+// ServerCount returns the number of nodes currently connected, this one included.
+func (adp *Adapter) ServerCount() int {
+	return adp.node.serverCount()
+}
+
+func (adp *Adapter) ServerSideEmit(packet []interface{}) {
+	adp.node.broadcast(&message{
+		Type: messageServerSideEmit,
+		Nsp:  adp.nsp.Name(),
+		Args: wire.Normalize(packet).([]interface{}),
+	})
+}
+
+// ServerSideEmitWithAck waits for every connected node, at most for the
+// requests timeout when ctx has no deadline.
+func (adp *Adapter) ServerSideEmitWithAck(ctx context.Context, packet []interface{}) ([]*socketigo.AckResponse, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, adp.node.requestsTimeout)
+		defer cancel()
+	}
+
+	req := adp.newRequest()
+	msg := &message{
+		Type: messageServerSideEmit,
+		Nsp:  adp.nsp.Name(),
+		Args: wire.Normalize(packet).([]interface{}),
+	}
+	if !adp.node.request(msg, req) {
+		return nil, nil
+	}
+
+	select {
+	case <-req.done:
+		return req.responses, nil
+	case <-ctx.Done():
+		adp.node.lock.Lock()
+		defer adp.node.lock.Unlock()
+		delete(adp.node.requests, msg.RequestId)
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return req.responses, fmt.Errorf("serverSideEmit: %w: missing %d responses", ErrRequestTimeout, len(req.pending))
+		}
+		return req.responses, ctx.Err()
+	}
+}
+
+func (adp *Adapter) newRequest() *pendingRequest {
+	return &pendingRequest{
+		nsp:     adp.nsp,
+		pending: make(map[string]struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// request sends msg to every connected node and waits for their responses
+// in req, reporting false when there is no node to ask.
+func (n *Node) request(msg *message, req *pendingRequest) bool {
+	msg.RequestId = wire.NewUid()
+
+	n.lock.Lock()
+	peers := n.peerList()
+	if len(peers) == 0 {
+		n.lock.Unlock()
+		return false
+	}
+	for _, p := range peers {
+		req.pending[p.uid] = struct{}{}
+	}
+	n.requests[msg.RequestId] = req
+	n.lock.Unlock()
+
+	n.send(peers, msg)
+	return true
+}
+
+// onMessage handles a message sent by the peer from.
+func (n *Node) onMessage(from string, msg *message) {
+	switch msg.Type {
+	case messageFetchSocketsResponse, messageServerSideEmitResponse, messageAllRoomsResponse:
+		n.onResponse(from, msg)
+		return
+	case messageBroadcastClientCount:
+		n.onClientCount(from, msg)
+		return
+	case messageBroadcastAck:
+		n.onAck(msg)
+		return
+	}
+
+	// Namespaces created on the fly may not exist on this node yet.
+	adp := n.adapter(msg.Nsp)
+	if adp == nil {
+		switch msg.Type {
+		case messageFetchSockets:
+			n.sendTo(from, &message{Type: messageFetchSocketsResponse, RequestId: msg.RequestId})
+		case messageAllRooms:
+			n.sendTo(from, &message{Type: messageAllRoomsResponse, RequestId: msg.RequestId})
+		case messageBroadcastWithAck:
+			n.sendTo(from, &message{Type: messageBroadcastClientCount, RequestId: msg.RequestId})
+		}
+		return
+	}
+	// Handlers run off the read loop, which must keep up with
+	// heartbeats and the other messages of the peer.
+	if msg.Type == messageServerSideEmit {
+		if msg.RequestId == "" {
+			go adp.nsp.HandleServerSideEmit(msg.Args, nil)
+			return
+		}
+		go adp.nsp.HandleServerSideEmit(msg.Args, func(args ...interface{}) {
+			n.sendTo(from, &message{
+				Type:      messageServerSideEmitResponse,
+				RequestId: msg.RequestId,
+				Args:      wire.Normalize(args).([]interface{}),
+			})
+		})
+		return
+	}
+	if msg.Type == messageAllRooms {
+		rooms, _ := adp.InMemoryAdapter.AllRooms()
+		resp := &message{
+			Type:      messageAllRoomsResponse,
+			RequestId: msg.RequestId,
+			Rooms:     make([]string, 0, len(rooms)),
+		}
+		for room := range rooms {
+			resp.Rooms = append(resp.Rooms, room)
+		}
+		n.sendTo(from, resp)
+		return
+	}
+
+	if msg.Opts == nil {
+		adp.logger.Debugf("Message without options %+v", msg)
+		return
+	}
+	opts := msg.Opts.ToOptions()
+
+	switch msg.Type {
+	case messageBroadcast:
+		if msg.Packet != nil {
+			adp.InMemoryAdapter.Broadcast(msg.Packet.ToPacket(), opts)
+		}
+
+	case messageSocketsJoin:
+		adp.InMemoryAdapter.AddSockets(opts, msg.Rooms)
+
+	case messageSocketsLeave:
+		adp.InMemoryAdapter.DelSockets(opts, msg.Rooms)
+
+	case messageDisconnectSockets:
+		// As for server side handlers, disconnect handlers run off the read loop.
+		go adp.InMemoryAdapter.DisconnectSockets(opts, msg.Close)
+
+	case messageFetchSockets:
+		sockets, err := adp.InMemoryAdapter.FetchSockets(opts)
+		if err != nil {
+			adp.logger.Error("FetchSockets: ", err)
+		}
+		for _, socket := range sockets {
+			socket.Data = wire.Normalize(socket.Data).(map[string]interface{})
+			socket.Handshake.Headers = stripCredentials(socket.Handshake.Headers)
+		}
+		n.sendTo(from, &message{
+			Type:      messageFetchSocketsResponse,
+			RequestId: msg.RequestId,
+			Sockets:   sockets,
+		})
+
+	case messageBroadcastWithAck:
+		if msg.Packet == nil {
+			n.sendTo(from, &message{Type: messageBroadcastClientCount, RequestId: msg.RequestId})
+			return
+		}
+		adp.InMemoryAdapter.BroadcastWithAck(msg.Packet.ToPacket(), opts, func(count int) {
+			n.sendTo(from, &message{
+				Type:        messageBroadcastClientCount,
+				RequestId:   msg.RequestId,
+				ClientCount: count,
+			})
+		}, func(sid string, resp *socketigo.AckResponse) {
+			n.sendTo(from, &message{
+				Type:      messageBroadcastAck,
+				RequestId: msg.RequestId,
+				Sid:       sid,
+				Args:      wire.Normalize(resp.Args()).([]interface{}),
+			})
+		})
+
+	default:
+		adp.logger.Debugf("Unsupported message type %d", msg.Type)
+	}
+}
+
+func (n *Node) onResponse(from string, msg *message) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	req, ok := n.requests[msg.RequestId]
+	if !ok {
+		return
+	}
+	if _, ok := req.pending[from]; !ok {
+		return
+	}
+
+	delete(req.pending, from)
+	switch msg.Type {
+	case messageFetchSocketsResponse:
+		req.sockets = append(req.sockets, msg.Sockets...)
+	case messageServerSideEmitResponse:
+		req.responses = append(req.responses, socketigo.NewAckResponse(req.nsp, msg.Args...))
+	case messageAllRoomsResponse:
+		for _, room := range msg.Rooms {
+			req.rooms[room] = struct{}{}
+		}
+	}
+	if len(req.pending) == 0 {
+		delete(n.requests, msg.RequestId)
+		close(req.done)
+	}
+}
+
+func (n *Node) onClientCount(from string, msg *message) {
+	n.lock.Lock()
+	req, ok := n.ackRequests[msg.RequestId]
+	if ok {
+		_, ok = req.pending[from]
+		delete(req.pending, from)
+	}
+	n.lock.Unlock()
+
+	if ok {
+		req.clientCount(msg.ClientCount)
+	}
+}
+
+func (n *Node) onAck(msg *message) {
+	n.lock.RLock()
+	req, ok := n.ackRequests[msg.RequestId]
+	n.lock.RUnlock()
+
+	if ok {
+		req.ack(msg.Sid, socketigo.NewAckResponse(req.nsp, msg.Args...))
+	}
+}
+
+// credentialHeaders are left out of the handshakes sent to other nodes.
+var credentialHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+func stripCredentials(headers http.Header) http.Header {
+	headers = headers.Clone()
+	for _, name := range credentialHeaders {
+		headers.Del(name)
+	}
+	return headers
+}
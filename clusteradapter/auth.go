@@ -0,0 +1,33 @@
+package clusteradapter
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+)
+
+// Each side of a connection sends a random nonce in its hello, and proves it
+// knows the secret with a MAC of both nonces. The labels keep a node from
+// replaying a proof back to its sender.
+const (
+	nonceLen  = 16
+	macServer = "server"
+	macClient = "client"
+)
+
+func newNonce() []byte {
+	nonce := make([]byte, nonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return nonce
+}
+
+func (n *Node) mac(label string, nonces ...[]byte) []byte {
+	h := hmac.New(sha256.New, n.secret)
+	h.Write([]byte(label))
+	for _, nonce := range nonces {
+		h.Write(nonce)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,91 @@
+package clusteradapter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	socketigo "github.com/taogames/socket.igo"
+	"github.com/taogames/socket.igo/internal/wire"
+)
+
+type messageType int
+
+const (
+	messageHello messageType = iota
+	messageHeartbeat
+	messageBroadcast
+	messageBroadcastWithAck
+	messageBroadcastClientCount
+	messageBroadcastAck
+	messageSocketsJoin
+	messageSocketsLeave
+	messageDisconnectSockets
+	messageFetchSockets
+	messageFetchSocketsResponse
+	messageServerSideEmit
+	messageServerSideEmitResponse
+	messageAuth
+	messageAllRooms
+	messageAllRoomsResponse
+)
+
+// message is the single frame type exchanged between nodes, only the fields
+// relevant to its type are set.
+type message struct {
+	Type        messageType               `json:"type"`
+	Uid         string                    `json:"uid,omitempty"`
+	Addr        string                    `json:"addr,omitempty"`
+	Peers       []string                  `json:"peers,omitempty"`
+	Nonce       []byte                    `json:"nonce,omitempty"`
+	Mac         []byte                    `json:"mac,omitempty"`
+	Nsp         string                    `json:"nsp,omitempty"`
+	RequestId   string                    `json:"requestId,omitempty"`
+	Packet      *wire.Packet              `json:"packet,omitempty"`
+	Opts        *wire.Options             `json:"opts,omitempty"`
+	Rooms       []string                  `json:"rooms,omitempty"`
+	Close       bool                      `json:"close,omitempty"`
+	Sockets     []*socketigo.RemoteSocket `json:"sockets,omitempty"`
+	ClientCount int                       `json:"clientCount,omitempty"`
+	Sid         string                    `json:"sid,omitempty"`
+	Args        []interface{}             `json:"args,omitempty"`
+}
+
+// maxFrameSize bounds the frames read from peers.
+const maxFrameSize = 64 << 20
+
+// Frames are a big-endian uint32 length followed by the MessagePack body.
+func encodeFrame(msg *message) ([]byte, error) {
+	body, err := wire.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	copy(frame[4:], body)
+	return frame, nil
+}
+
+func readFrame(r io.Reader) (*message, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds %d", size, maxFrameSize)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	var msg message
+	if err := wire.NewDecoder(body).Decode(&msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
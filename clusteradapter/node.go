@@ -0,0 +1,741 @@
+// Package clusteradapter provides a socket.igo adapter connecting the servers
+// of a cluster directly over TCP, without an external broker.
+//
+// Every server runs a Node listening for its peers and dialing them, either
+// from a static list or from DNS records. Nodes learn the address of the
+// peers dialing them, and gossip the addresses of their peers along with
+// heartbeats, so a new server only needs to know one member. Nodes share a
+// secret set with WithSecret, which keeps other hosts reaching the listener
+// from joining.
+package clusteradapter
+
+import (
+	"crypto/hmac"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	socketigo "github.com/taogames/socket.igo"
+	"github.com/taogames/socket.igo/internal/wire"
+	"go.uber.org/zap"
+)
+
+type Option func(n *Node)
+
+// WithPeers sets addresses of other nodes, dialed until the node is closed.
+func WithPeers(addrs ...string) Option {
+	return func(n *Node) {
+		for _, addr := range addrs {
+			n.static[addr] = struct{}{}
+		}
+	}
+}
+
+// WithDNSDiscovery periodically resolves host and dials every address found
+// on the given port, e.g. a Kubernetes headless service.
+func WithDNSDiscovery(host, port string, interval time.Duration) Option {
+	return func(n *Node) {
+		n.dnsHost = host
+		n.dnsPort = port
+		n.dnsInterval = interval
+	}
+}
+
+// WithAdvertiseAddr sets the address peers should dial to reach this node.
+// By default the listener's address is sent, with an unspecified host
+// replaced by the one peers see the connection coming from.
+func WithAdvertiseAddr(addr string) Option {
+	return func(n *Node) {
+		n.advertiseAddr = addr
+	}
+}
+
+// WithHeartbeat sets how often heartbeats are sent on every connection, and
+// how long a silent connection is kept before the peer is considered gone.
+func WithHeartbeat(interval, timeout time.Duration) Option {
+	return func(n *Node) {
+		n.heartbeatInterval = interval
+		n.heartbeatTimeout = timeout
+	}
+}
+
+// WithRetryInterval sets the delay between two attempts to dial a peer.
+func WithRetryInterval(interval time.Duration) Option {
+	return func(n *Node) {
+		n.retryInterval = interval
+	}
+}
+
+// WithRequestsTimeout bounds how long requests to other nodes, like
+// FetchSockets, wait for their responses.
+func WithRequestsTimeout(timeout time.Duration) Option {
+	return func(n *Node) {
+		n.requestsTimeout = timeout
+	}
+}
+
+// WithSecret sets a secret shared by the nodes of the cluster. Nodes prove
+// they know it when connecting and drop the peers that do not, the traffic
+// itself is not encrypted.
+func WithSecret(secret string) Option {
+	return func(n *Node) {
+		n.secret = []byte(secret)
+	}
+}
+
+// WithInsecure lets a node start without a secret, any host reaching its
+// listener can then join the cluster.
+func WithInsecure() Option {
+	return func(n *Node) {
+		n.insecure = true
+	}
+}
+
+var ErrNoSecret = errors.New("clusteradapter: no secret set, see WithSecret and WithInsecure")
+
+func WithLogger(logger *zap.SugaredLogger) Option {
+	return func(n *Node) {
+		n.logger = logger
+	}
+}
+
+type Node struct {
+	uid           string
+	listener      net.Listener
+	advertiseAddr string
+	secret        []byte
+	insecure      bool
+
+	static      map[string]struct{}
+	dnsHost     string
+	dnsPort     string
+	dnsInterval time.Duration
+
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	retryInterval     time.Duration
+	requestsTimeout   time.Duration
+
+	lock        sync.RWMutex
+	discovered  map[string]struct{}
+	inbound     map[string]int
+	dialing     map[string]struct{}
+	known       map[string]string // Dialed addresses to the uid answering there
+	links       map[*link]struct{}
+	peers       map[string]*peer
+	adapters    map[string]*Adapter
+	requests    map[string]*pendingRequest
+	ackRequests map[string]*ackRequest
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	logger *zap.SugaredLogger
+}
+
+// peer is a node this node dialed, messages to it go through its link.
+type peer struct {
+	*link
+	uid  string
+	addr string
+}
+
+// link is a connection between two nodes. Frames are written by a goroutine
+// of its own, so that a slow peer does not hold up the others.
+type link struct {
+	conn net.Conn
+	out  chan []byte
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// linkQueueSize bounds the frames waiting to be written to a peer, which is
+// dropped when it falls that far behind.
+const linkQueueSize = 1024
+
+var errLinkClosed = errors.New("link closed")
+
+// NewNode starts listening for peers on listenAddr and dialing the
+// configured ones. It requires WithSecret, or WithInsecure to do without.
+func NewNode(listenAddr string, opts ...Option) (*Node, error) {
+	n := &Node{
+		uid:               wire.NewUid(),
+		static:            make(map[string]struct{}),
+		heartbeatInterval: 5 * time.Second,
+		heartbeatTimeout:  15 * time.Second,
+		retryInterval:     2 * time.Second,
+		requestsTimeout:   5 * time.Second,
+		discovered:        make(map[string]struct{}),
+		inbound:           make(map[string]int),
+		dialing:           make(map[string]struct{}),
+		known:             make(map[string]string),
+		links:             make(map[*link]struct{}),
+		peers:             make(map[string]*peer),
+		adapters:          make(map[string]*Adapter),
+		requests:          make(map[string]*pendingRequest),
+		ackRequests:       make(map[string]*ackRequest),
+		closed:            make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(n)
+	}
+
+	if len(n.secret) == 0 && !n.insecure {
+		return nil, ErrNoSecret
+	}
+
+	if n.logger == nil {
+		logger, err := zap.NewProduction()
+		if err != nil {
+			return nil, err
+		}
+		n.logger = logger.Sugar()
+	}
+	n.logger = n.logger.With("Node", n.uid)
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	n.listener = listener
+	if n.advertiseAddr == "" {
+		n.advertiseAddr = listener.Addr().String()
+	}
+
+	go n.accept()
+	for addr := range n.static {
+		n.dial(addr)
+	}
+	if n.dnsHost != "" {
+		go n.discover()
+	}
+
+	return n, nil
+}
+
+// Uid returns the random id identifying this node to its peers.
+func (n *Node) Uid() string {
+	return n.uid
+}
+
+// Addr returns the address the node listens on.
+func (n *Node) Addr() net.Addr {
+	return n.listener.Addr()
+}
+
+// Peers returns the uids of the nodes currently connected.
+func (n *Node) Peers() []string {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	uids := make([]string, 0, len(n.peers))
+	for uid := range n.peers {
+		uids = append(uids, uid)
+	}
+	return uids
+}
+
+// AdapterIniter returns the socketigo.AdapterIniter to pass to
+// socketigo.WithAdapter, every namespace then shares this node.
+func (n *Node) AdapterIniter() socketigo.AdapterIniter {
+	return func(nsp *socketigo.Namespace) socketigo.Adapter {
+		return n.newAdapter(nsp)
+	}
+}
+
+// Close stops listening and drops every connection to the peers.
+func (n *Node) Close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		close(n.closed)
+		err = n.listener.Close()
+
+		n.lock.Lock()
+		links := n.links
+		n.links = make(map[*link]struct{})
+		n.lock.Unlock()
+
+		for l := range links {
+			l.close()
+		}
+	})
+	return err
+}
+
+func (n *Node) isClosed() bool {
+	select {
+	case <-n.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (n *Node) accept() {
+	for {
+		conn, err := n.listener.Accept()
+		if err != nil {
+			if n.isClosed() {
+				return
+			}
+			n.logger.Error("Accept: ", err)
+			time.Sleep(n.retryInterval)
+			continue
+		}
+		go n.serve(conn)
+	}
+}
+
+// serve handles a connection dialed by a peer, through which it sends its
+// messages to this node.
+func (n *Node) serve(conn net.Conn) {
+	l := n.newLink(conn)
+	if l == nil {
+		return
+	}
+	defer n.dropLink(l)
+
+	conn.SetReadDeadline(time.Now().Add(n.heartbeatTimeout))
+	hello, err := readFrame(conn)
+	if err != nil || hello.Type != messageHello || len(hello.Nonce) != nonceLen {
+		n.logger.Debugf("Invalid hello from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	nonce := newNonce()
+	if err := n.write(l, &message{Type: messageHello, Uid: n.uid, Nonce: nonce, Mac: n.mac(macServer, hello.Nonce, nonce)}); err != nil {
+		return
+	}
+	if hello.Uid == n.uid {
+		return
+	}
+
+	// Nothing is read from or told to the peer before it proves it knows the
+	// secret.
+	auth, err := readFrame(conn)
+	if err != nil {
+		n.logger.Debugf("Read auth from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	if auth.Type != messageAuth || !hmac.Equal(auth.Mac, n.mac(macClient, nonce, hello.Nonce)) {
+		n.logger.Warnf("Peer %s at %s failed to authenticate", hello.Uid, conn.RemoteAddr())
+		return
+	}
+	if err := n.write(l, &message{Type: messageHeartbeat, Peers: n.peerAddrs()}); err != nil {
+		return
+	}
+
+	// Dial back whoever dials us, as long as it stays connected.
+	addr := peerAddr(hello.Addr, conn.RemoteAddr())
+	if addr != "" {
+		n.lock.Lock()
+		n.inbound[addr]++
+		_, connected := n.peers[hello.Uid]
+		n.lock.Unlock()
+
+		defer func() {
+			n.lock.Lock()
+			if n.inbound[addr]--; n.inbound[addr] <= 0 {
+				delete(n.inbound, addr)
+			}
+			n.lock.Unlock()
+		}()
+
+		if !connected {
+			n.dial(addr)
+		}
+	}
+
+	go n.heartbeat(l)
+	err = n.readLoop(l, func(msg *message) {
+		n.onMessage(hello.Uid, msg)
+	})
+	n.logger.Debugf("Connection from %s closed: %v", hello.Uid, err)
+}
+
+// peerAddr returns the address to dial a peer at, from the address it
+// advertised and the one its connection comes from.
+func peerAddr(advertised string, remote net.Addr) string {
+	host, port, err := net.SplitHostPort(advertised)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		tcpAddr, ok := remote.(*net.TCPAddr)
+		if !ok {
+			return ""
+		}
+		host = tcpAddr.IP.String()
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func (n *Node) discover() {
+	ticker := time.NewTicker(n.dnsInterval)
+	defer ticker.Stop()
+
+	for {
+		hosts, err := net.LookupHost(n.dnsHost)
+		if err != nil {
+			n.logger.Warnf("Lookup %s: %v", n.dnsHost, err)
+		} else {
+			discovered := make(map[string]struct{}, len(hosts))
+			for _, host := range hosts {
+				discovered[net.JoinHostPort(host, n.dnsPort)] = struct{}{}
+			}
+
+			n.lock.Lock()
+			n.discovered = discovered
+			n.lock.Unlock()
+
+			for addr := range discovered {
+				n.dial(addr)
+			}
+		}
+
+		select {
+		case <-n.closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// wanted reports whether the node should keep dialing addr.
+func (n *Node) wanted(addr string) bool {
+	if n.isClosed() {
+		return false
+	}
+
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	if _, ok := n.static[addr]; ok {
+		return true
+	}
+	if _, ok := n.discovered[addr]; ok {
+		return true
+	}
+	return n.inbound[addr] > 0
+}
+
+// learn dials the peers of a peer, to complete the mesh.
+func (n *Node) learn(addrs []string) {
+	for _, addr := range addrs {
+		n.dial(addr)
+	}
+}
+
+func (n *Node) dial(addr string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if _, ok := n.dialing[addr]; ok || addr == n.advertiseAddr {
+		return
+	}
+	if uid, ok := n.known[addr]; ok {
+		if _, connected := n.peers[uid]; connected || uid == n.uid {
+			return
+		}
+	}
+	n.dialing[addr] = struct{}{}
+
+	go n.dialLoop(addr)
+}
+
+func (n *Node) dialLoop(addr string) {
+	defer func() {
+		n.lock.Lock()
+		delete(n.dialing, addr)
+		n.lock.Unlock()
+	}()
+
+	for n.connect(addr) && n.wanted(addr) {
+		select {
+		case <-n.closed:
+			return
+		case <-time.After(n.retryInterval):
+		}
+	}
+}
+
+// connect dials addr and holds the connection until it fails, reporting
+// whether the address is worth dialing again.
+func (n *Node) connect(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, n.heartbeatTimeout)
+	if err != nil {
+		n.logger.Debugf("Dial %s: %v", addr, err)
+		return true
+	}
+
+	l := n.newLink(conn)
+	if l == nil {
+		return false
+	}
+	defer n.dropLink(l)
+
+	nonce := newNonce()
+	if err := n.write(l, &message{Type: messageHello, Uid: n.uid, Addr: n.advertiseAddr, Nonce: nonce}); err != nil {
+		return true
+	}
+	conn.SetReadDeadline(time.Now().Add(n.heartbeatTimeout))
+	hello, err := readFrame(conn)
+	if err != nil || hello.Type != messageHello || len(hello.Nonce) != nonceLen {
+		n.logger.Debugf("Invalid hello from %s: %v", addr, err)
+		return true
+	}
+
+	n.lock.Lock()
+	n.known[addr] = hello.Uid
+	n.lock.Unlock()
+
+	if hello.Uid == n.uid {
+		n.logger.Debugf("%s is this node", addr)
+		return false
+	}
+
+	if !hmac.Equal(hello.Mac, n.mac(macServer, nonce, hello.Nonce)) {
+		n.logger.Warnf("Peer %s at %s failed to authenticate", hello.Uid, addr)
+		return true
+	}
+	if err := n.write(l, &message{Type: messageAuth, Mac: n.mac(macClient, hello.Nonce, nonce)}); err != nil {
+		return true
+	}
+	// The peer answers a valid proof with its peers, and closes the
+	// connection otherwise.
+	welcome, err := readFrame(conn)
+	if err != nil || welcome.Type != messageHeartbeat {
+		n.logger.Warnf("Rejected by %s at %s: %v", hello.Uid, addr, err)
+		return true
+	}
+
+	p := &peer{link: l, uid: hello.Uid, addr: addr}
+	if !n.addPeer(p) {
+		n.logger.Debugf("Already connected to %s, dropping %s", p.uid, addr)
+		return false
+	}
+	n.logger.Infof("Connected to peer %s at %s", p.uid, addr)
+	n.learn(welcome.Peers)
+
+	go n.heartbeat(l)
+	err = n.readLoop(l, func(*message) {})
+
+	n.removePeer(p)
+	n.logger.Infof("Lost peer %s at %s: %v", p.uid, addr, err)
+	return true
+}
+
+func (n *Node) newLink(conn net.Conn) *link {
+	l := &link{
+		conn: conn,
+		out:  make(chan []byte, linkQueueSize),
+		done: make(chan struct{}),
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.isClosed() {
+		conn.Close()
+		return nil
+	}
+	n.links[l] = struct{}{}
+	go n.writeLoop(l)
+	return l
+}
+
+func (n *Node) dropLink(l *link) {
+	n.lock.Lock()
+	delete(n.links, l)
+	n.lock.Unlock()
+
+	l.close()
+}
+
+func (l *link) close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.conn.Close()
+	})
+}
+
+func (n *Node) write(l *link, msg *message) error {
+	frame, err := encodeFrame(msg)
+	if err != nil {
+		n.logger.Errorf("Encode %+v: %v", msg, err)
+		return err
+	}
+	return n.writeFrame(l, frame)
+}
+
+// writeFrame queues the frame for the writer of the link. It closes a link
+// whose queue is full, which makes its read loop drop the peer.
+func (n *Node) writeFrame(l *link, frame []byte) error {
+	select {
+	case <-l.done:
+		return errLinkClosed
+	default:
+	}
+
+	select {
+	case l.out <- frame:
+		return nil
+	default:
+		n.logger.Warnf("Peer at %s is too slow, dropping it", l.conn.RemoteAddr())
+		l.close()
+		return errLinkClosed
+	}
+}
+
+// writeLoop writes the queued frames, closing the link on failure.
+func (n *Node) writeLoop(l *link) {
+	for {
+		select {
+		case <-l.done:
+			return
+		case frame := <-l.out:
+			l.conn.SetWriteDeadline(time.Now().Add(n.heartbeatTimeout))
+			if _, err := l.conn.Write(frame); err != nil {
+				n.logger.Debugf("Write to %s: %v", l.conn.RemoteAddr(), err)
+				l.close()
+				return
+			}
+		}
+	}
+}
+
+func (n *Node) heartbeat(l *link) {
+	ticker := time.NewTicker(n.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if err := n.write(l, &message{Type: messageHeartbeat, Peers: n.peerAddrs()}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (n *Node) readLoop(l *link, handle func(msg *message)) error {
+	for {
+		l.conn.SetReadDeadline(time.Now().Add(n.heartbeatTimeout))
+		msg, err := readFrame(l.conn)
+		if err != nil {
+			l.close()
+			return err
+		}
+		if msg.Type == messageHeartbeat {
+			n.learn(msg.Peers)
+		} else {
+			handle(msg)
+		}
+	}
+}
+
+func (n *Node) addPeer(p *peer) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if _, ok := n.peers[p.uid]; ok {
+		return false
+	}
+	n.peers[p.uid] = p
+	return true
+}
+
+// removePeer drops the peer and stops waiting for its responses.
+func (n *Node) removePeer(p *peer) {
+	var clientCounts []func(n int)
+
+	n.lock.Lock()
+	if n.peers[p.uid] == p {
+		delete(n.peers, p.uid)
+	}
+	for requestId, req := range n.requests {
+		if _, ok := req.pending[p.uid]; ok {
+			delete(req.pending, p.uid)
+			if len(req.pending) == 0 {
+				delete(n.requests, requestId)
+				close(req.done)
+			}
+		}
+	}
+	for _, req := range n.ackRequests {
+		if _, ok := req.pending[p.uid]; ok {
+			delete(req.pending, p.uid)
+			clientCounts = append(clientCounts, req.clientCount)
+		}
+	}
+	n.lock.Unlock()
+
+	for _, clientCount := range clientCounts {
+		clientCount(0)
+	}
+}
+
+func (n *Node) peerList() []*peer {
+	peers := make([]*peer, 0, len(n.peers))
+	for _, p := range n.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+func (n *Node) peerAddrs() []string {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	addrs := make([]string, 0, len(n.peers))
+	for _, p := range n.peers {
+		addrs = append(addrs, p.addr)
+	}
+	return addrs
+}
+
+func (n *Node) serverCount() int {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return len(n.peers) + 1
+}
+
+// send queues msg for every given peer.
+func (n *Node) send(peers []*peer, msg *message) {
+	if len(peers) == 0 {
+		return
+	}
+
+	frame, err := encodeFrame(msg)
+	if err != nil {
+		n.logger.Errorf("Encode %+v: %v", msg, err)
+		return
+	}
+	for _, p := range peers {
+		n.writeFrame(p.link, frame)
+	}
+}
+
+func (n *Node) broadcast(msg *message) {
+	n.lock.RLock()
+	peers := n.peerList()
+	n.lock.RUnlock()
+
+	n.send(peers, msg)
+}
+
+func (n *Node) sendTo(uid string, msg *message) {
+	n.lock.RLock()
+	p, ok := n.peers[uid]
+	n.lock.RUnlock()
+
+	if !ok {
+		n.logger.Debugf("Peer %s is gone, dropping %+v", uid, msg)
+		return
+	}
+	n.send([]*peer{p}, msg)
+}
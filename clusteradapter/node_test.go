@@ -0,0 +1,238 @@
+package clusteradapter_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/taogames/engine.igo/utils/idgen"
+	socketigo "github.com/taogames/socket.igo"
+	"github.com/taogames/socket.igo/clusteradapter"
+	"github.com/taogames/socket.igo/internal/sockettest"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	idgen.Default = &sockettest.IDGenerator{}
+	os.Exit(m.Run())
+}
+
+func newNode(t *testing.T, opts ...clusteradapter.Option) *clusteradapter.Node {
+	t.Helper()
+	opts = append([]clusteradapter.Option{
+		clusteradapter.WithSecret("secret"),
+		clusteradapter.WithLogger(zap.NewNop().Sugar()),
+		clusteradapter.WithRetryInterval(20 * time.Millisecond),
+		clusteradapter.WithHeartbeat(50*time.Millisecond, 250*time.Millisecond),
+	}, opts...)
+	n, err := clusteradapter.NewNode("127.0.0.1:0", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+func waitPeers(t *testing.T, n *clusteradapter.Node, count int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(n.Peers()) != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("node has %d peers, want %d", len(n.Peers()), count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newServers starts two connected nodes, each with a server using it.
+func newServers(t *testing.T) (*socketigo.Server, *socketigo.Server) {
+	t.Helper()
+	a := newNode(t)
+	b := newNode(t, clusteradapter.WithPeers(a.Addr().String()))
+	waitPeers(t, a, 1)
+	waitPeers(t, b, 1)
+
+	servers := make([]*socketigo.Server, 2)
+	for i, n := range []*clusteradapter.Node{a, b} {
+		srv, err := socketigo.NewServer(socketigo.WithAdapter(n.AdapterIniter()), socketigo.WithLogger(zap.NewNop().Sugar()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(srv.Close)
+		servers[i] = srv
+	}
+	return servers[0], servers[1]
+}
+
+func TestMesh(t *testing.T) {
+	a := newNode(t)
+	b := newNode(t, clusteradapter.WithPeers(a.Addr().String()))
+	c := newNode(t, clusteradapter.WithPeers(b.Addr().String()))
+
+	for _, n := range []*clusteradapter.Node{a, b, c} {
+		waitPeers(t, n, 2)
+	}
+}
+
+func TestNoSecret(t *testing.T) {
+	if _, err := clusteradapter.NewNode("127.0.0.1:0"); !errors.Is(err, clusteradapter.ErrNoSecret) {
+		t.Errorf("got %v, want ErrNoSecret", err)
+	}
+}
+
+func TestWrongSecret(t *testing.T) {
+	a := newNode(t)
+	b := newNode(t, clusteradapter.WithSecret("guess"), clusteradapter.WithPeers(a.Addr().String()))
+	c := newNode(t, clusteradapter.WithSecret(""), clusteradapter.WithInsecure(), clusteradapter.WithPeers(a.Addr().String()))
+
+	time.Sleep(300 * time.Millisecond)
+	for _, n := range []*clusteradapter.Node{a, b, c} {
+		if peers := n.Peers(); len(peers) != 0 {
+			t.Errorf("node %s connected to %v", n.Uid(), peers)
+		}
+	}
+}
+
+func TestSlowServerSideEmit(t *testing.T) {
+	srvA, srvB := newServers(t)
+
+	// The slow handler outlasts the heartbeat timeout, and must not hold up
+	// the messages that follow it.
+	release := make(chan struct{})
+	defer close(release)
+	srvB.OnServerSideEmit("slow", func() {
+		select {
+		case <-release:
+		case <-time.After(500 * time.Millisecond):
+		}
+	})
+	srvB.OnServerSideEmit("fast", func(ack func(...interface{})) {
+		ack("done")
+	})
+
+	srvA.ServerSideEmit("slow")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	responses, err := srvA.ServerSideEmitWithAck(ctx, "fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 {
+		t.Fatalf("got %d responses, want 1", len(responses))
+	}
+	var s string
+	if err := responses[0].Decode(&s); err != nil || s != "done" {
+		t.Errorf("got %q, %v", s, err)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if n := srvA.Of("/").Adapter().ServerCount(); n != 2 {
+		t.Errorf("got %d servers after the handler ran, want 2", n)
+	}
+}
+
+// listen serves srv over HTTP for the duration of the test.
+func listen(t *testing.T, srv *socketigo.Server) string {
+	t.Helper()
+	go srv.Accept()
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestFetchSocketsStripsCredentials(t *testing.T) {
+	srvA, srvB := newServers(t)
+	header := http.Header{}
+	header.Set("Cookie", "session=1")
+	header.Set("Authorization", "Bearer token")
+	header.Set("X-Client", "test")
+	c, err := sockettest.DialHeader(listen(t, srvB), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	if reply, err := c.Connect("/"); err != nil || !strings.HasPrefix(reply, "0{") {
+		t.Fatalf("connect: %q, %v", reply, err)
+	}
+
+	sockets, err := srvA.Of("/").FetchSockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sockets) != 1 {
+		t.Fatalf("got %d sockets, want 1", len(sockets))
+	}
+	headers := sockets[0].Handshake.Headers
+	if headers.Get("Cookie") != "" || headers.Get("Authorization") != "" || headers.Get("X-Client") != "test" {
+		t.Errorf("got headers %v", headers)
+	}
+}
+
+func TestAllRooms(t *testing.T) {
+	srvA, srvB := newServers(t)
+	srvB.Of("/").OnConnection(func(socket *socketigo.Socket) {
+		socket.Join("room")
+	})
+	c, err := sockettest.Dial(listen(t, srvB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	if reply, err := c.Connect("/"); err != nil || !strings.HasPrefix(reply, "0{") {
+		t.Fatalf("connect: %q, %v", reply, err)
+	}
+
+	want := []string{"room"}
+	for sid := range srvB.Of("/").Adapter().Sockets(nil) {
+		want = append(want, sid)
+	}
+	sort.Strings(want)
+
+	rooms, err := srvA.Of("/").Rooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rooms, ",") != strings.Join(want, ",") {
+		t.Errorf("got rooms %v, want %v", rooms, want)
+	}
+}
+
+// Disconnect handlers run off the read loop, and can wait for responses
+// arriving through it.
+func TestRemoteDisconnect(t *testing.T) {
+	srvA, srvB := newServers(t)
+	srvA.OnServerSideEmit("bye", func(ack func(...interface{})) {
+		ack("ok")
+	})
+	done := make(chan error, 1)
+	srvB.Of("/").OnConnection(func(socket *socketigo.Socket) {
+		socket.OnDisconnect(func(socketigo.DisconnectReason) {
+			_, err := srvB.ServerSideEmitWithAck(context.Background(), "bye")
+			done <- err
+		})
+	})
+	c, err := sockettest.Dial(listen(t, srvB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	if reply, err := c.Connect("/"); err != nil || !strings.HasPrefix(reply, "0{") {
+		t.Fatalf("connect: %q, %v", reply, err)
+	}
+
+	srvA.Of("/").DisconnectSockets(false)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the disconnect handler did not complete")
+	}
+}
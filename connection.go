@@ -2,6 +2,7 @@ package socketigo
 
 import (
 	"encoding/json"
+	"sync"
 
 	engineigo "github.com/taogames/engine.igo"
 	"github.com/taogames/engine.igo/message"
@@ -9,18 +10,94 @@ import (
 )
 
 type Connection struct {
-	server    *Server
-	session   *engineigo.Session
-	parser    Parser
-	socketIds map[string]*Socket // map<Namespace, socketId>
+	server  *Server
+	session *engineigo.Session
+	parser  Parser
+	decoder Decoder
+
+	handshake *Handshake
+
+	socketLock sync.RWMutex
+	socketIds  map[string]*Socket // map<Namespace, Socket>
 
 	logger *zap.SugaredLogger
 }
 
+func (conn *Connection) connect(packet *Packet) bool {
+	if conn.server.shuttingDown.Load() {
+		conn.ConnectError(packet.Namespace, ErrShuttingDown)
+		return false
+	}
+
+	auth, _ := packet.Data.(map[string]interface{})
+	nsp, ok := conn.server.namespace(packet.Namespace, auth)
+	if !ok {
+		conn.ConnectError(packet.Namespace, ErrInvalidNamespace)
+		return false
+	}
+
+	if _, ok := conn.socket(nsp.name); ok {
+		conn.ConnectError(packet.Namespace, ErrAlreadyConnected)
+		return true
+	}
+
+	handshake, _ := json.Marshal(packet.Data)
+	conn.Connect(nsp, handshake)
+	return true
+}
+
 func (conn *Connection) Connect(nsp *Namespace, handshake []byte) {
-	sid := conn.session.ID()
+	nsp.Connect(conn, handshake)
+}
+
+// ID returns the Engine.IO session id, shared by the sockets of every
+// namespace multiplexed over the connection.
+func (conn *Connection) ID() string {
+	return conn.session.ID()
+}
+
+func (conn *Connection) socket(nsp string) (*Socket, bool) {
+	conn.socketLock.RLock()
+	defer conn.socketLock.RUnlock()
+	socket, ok := conn.socketIds[nsp]
+	return socket, ok
+}
+
+// addSocket reports false when the connection already has a socket in the
+// namespace.
+func (conn *Connection) addSocket(socket *Socket) bool {
+	conn.socketLock.Lock()
+	defer conn.socketLock.Unlock()
+	if _, ok := conn.socketIds[socket.nsp.name]; ok {
+		return false
+	}
+	conn.socketIds[socket.nsp.name] = socket
+	return true
+}
+
+func (conn *Connection) removeSocket(socket *Socket) {
+	conn.socketLock.Lock()
+	defer conn.socketLock.Unlock()
+	if conn.socketIds[socket.nsp.name] == socket {
+		delete(conn.socketIds, socket.nsp.name)
+	}
+}
+
+func (conn *Connection) sockets() []*Socket {
+	conn.socketLock.RLock()
+	defer conn.socketLock.RUnlock()
+
+	sockets := make([]*Socket, 0, len(conn.socketIds))
+	for _, socket := range conn.socketIds {
+		sockets = append(sockets, socket)
+	}
+	return sockets
+}
+
+func (conn *Connection) writeConnect(nsp *Namespace, socket *Socket) {
 	rData := connReply{
-		Sid: sid,
+		Sid: socket.Id,
+		Pid: socket.pid,
 	}
 	rPacket := &Packet{
 		Type:      PacketConnect,
@@ -33,11 +110,12 @@ func (conn *Connection) Connect(nsp *Namespace, handshake []byte) {
 		return
 	}
 	conn.WriteToEngine(msgs)
-
-	nsp.Connect(sid, conn, handshake)
 }
 
 func (conn *Connection) WriteToEngine(msgs []*message.Message) error {
+	conn.server.inflight.Add(1)
+	defer conn.server.inflight.Add(-1)
+
 	for _, msg := range msgs {
 		if err := conn.session.WriteMessage(msg); err != nil {
 			return err
@@ -67,7 +145,7 @@ func (conn *Connection) Start() {
 		if err != nil {
 			conn.logger.Error("conn.session.NextReader:", err)
 
-			for _, socket := range conn.socketIds {
+			for _, socket := range conn.sockets() {
 				socket.disconnect(true, DRTransportError)
 			}
 
@@ -80,7 +158,10 @@ func (conn *Connection) Start() {
 }
 
 func (conn *Connection) onPacket(mt message.MessageType, data []byte) {
-	packet, err := conn.parser.Decode(&message.Message{Type: mt, Data: data})
+	conn.server.inflight.Add(1)
+	defer conn.server.inflight.Add(-1)
+
+	packet, err := conn.decoder.Decode(&message.Message{Type: mt, Data: data})
 	if err != nil {
 		conn.logger.Error("conn.parser.Decode:", err)
 		conn.Close()
@@ -91,22 +172,26 @@ func (conn *Connection) onPacket(mt message.MessageType, data []byte) {
 		return
 	}
 
-	nsp, ok := conn.server.nsps[packet.Namespace]
+	if packet.Type == PacketConnect {
+		if !conn.connect(packet) {
+			conn.Close()
+		}
+		return
+	}
+
+	socket, ok := conn.socket(packet.Namespace)
 	if !ok {
-		conn.ConnectError(packet.Namespace, ErrInvalidNamespace)
-		conn.Close()
+		conn.logger.Debugf("no socket for namespace %s", packet.Namespace)
+		return
 	}
 
 	switch packet.Type {
-	case PacketConnect:
-		handshake, _ := json.Marshal(packet.Data)
-		conn.Connect(nsp, handshake)
 	case PacketDisconnect:
-		socket := conn.socketIds[packet.Namespace]
 		socket.disconnect(false, DRClientNamespaceDisconnect)
 	case PacketEvent, PacketBinaryEvent:
-		socket := conn.socketIds[packet.Namespace]
-		socket.dispatch(packet)
+		socket.enqueue(packet)
+	case PacketAck, PacketBinaryAck:
+		socket.onAck(packet)
 	default:
 		// Not supported
 	}
@@ -114,5 +199,6 @@ func (conn *Connection) onPacket(mt message.MessageType, data []byte) {
 }
 
 func (conn *Connection) Close() {
-	conn.session.Close()
+	conn.server.removeConn(conn)
+	conn.server.sessions.close(conn.session)
 }
@@ -0,0 +1,68 @@
+package socketigo
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A second CONNECT to the same namespace is rejected, even while the first
+// one is still going through the middlewares, and the socket stays connected.
+func TestDuplicateConnect(t *testing.T) {
+	srv, url := newTestServer(t)
+	release := make(chan struct{})
+	srv.Of("/").Use(func(socket *Socket, next func(error)) {
+		go func() {
+			<-release
+			next(nil)
+		}()
+	})
+	var connections atomic.Int32
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		connections.Add(1)
+		socket.On("ping", func() {
+			socket.Emit("pong")
+		})
+	})
+	c := dial(t, url)
+
+	for i := 0; i < 2; i++ {
+		if err := c.Send(`0`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+
+	// The replies may come in any order.
+	var replies []string
+	for i := 0; i < 2; i++ {
+		reply, err := c.Expect(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, reply)
+	}
+	sort.Strings(replies)
+	if !strings.HasPrefix(replies[0], "0{") || replies[1] != `4{"message":"Already connected"}` {
+		t.Fatalf("got replies %q", replies)
+	}
+
+	if err := c.Send(`0`); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := c.Expect(time.Second); err != nil || reply != `4{"message":"Already connected"}` {
+		t.Fatalf("got %q, %v", reply, err)
+	}
+
+	if err := c.Send(`2["ping"]`); err != nil {
+		t.Fatal(err)
+	}
+	if packet, err := c.Expect(time.Second); err != nil || packet != `2["pong"]` {
+		t.Errorf("got %q, %v", packet, err)
+	}
+	if n := connections.Load(); n != 1 {
+		t.Errorf("got %d connections", n)
+	}
+}
@@ -0,0 +1,258 @@
+package socketigo
+
+import "sync"
+
+// OverflowPolicy tells what happens to an event received while the queue of
+// its socket is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock stops reading the connection until the queue has room.
+	// Handlers must not then wait for an acknowledgement of the same
+	// connection, which would not be read.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop drops the event.
+	OverflowDrop
+	// OverflowDisconnect disconnects the socket.
+	OverflowDisconnect
+)
+
+type dispatchMode int
+
+const (
+	dispatchInline dispatchMode = iota
+	dispatchSocket
+	dispatchPool
+)
+
+// WithSocketQueues runs the handlers of each socket in order on a goroutine
+// of its own, alive while the socket has events queued, instead of on the
+// goroutine reading the connection. At most depth events wait per socket.
+func WithSocketQueues(depth int, policy OverflowPolicy) ServerOption {
+	return func(s *Server) {
+		s.dispatchMode = dispatchSocket
+		s.queueDepth = depth
+		s.overflow = policy
+	}
+}
+
+// WithWorkerPool runs the handlers on a fixed number of workers shared by
+// every socket. The events of a socket are still handled in order, one at a
+// time, and at most depth of them wait per socket.
+func WithWorkerPool(workers, depth int, policy OverflowPolicy) ServerOption {
+	return func(s *Server) {
+		s.dispatchMode = dispatchPool
+		s.workers = workers
+		s.queueDepth = depth
+		s.overflow = policy
+	}
+}
+
+// mailbox queues the events of a socket, running them one at a time.
+type mailbox struct {
+	lock     sync.Mutex
+	notFull  *sync.Cond
+	tasks    []func()
+	depth    int
+	running  bool
+	closed   bool
+	schedule func(mb *mailbox)
+}
+
+func newMailbox(depth int, schedule func(mb *mailbox)) *mailbox {
+	if depth < 1 {
+		depth = 1
+	}
+	mb := &mailbox{
+		depth:    depth,
+		schedule: schedule,
+	}
+	mb.notFull = sync.NewCond(&mb.lock)
+	return mb
+}
+
+// push queues a task, reporting false when the queue is full and the
+// policy is not to wait.
+func (mb *mailbox) push(task func(), policy OverflowPolicy) bool {
+	mb.lock.Lock()
+	for policy == OverflowBlock && !mb.closed && len(mb.tasks) >= mb.depth {
+		mb.notFull.Wait()
+	}
+	if !mb.closed && len(mb.tasks) >= mb.depth {
+		mb.lock.Unlock()
+		return false
+	}
+
+	mb.tasks = append(mb.tasks, task)
+	if mb.running {
+		mb.lock.Unlock()
+		return true
+	}
+	mb.running = true
+	mb.lock.Unlock()
+
+	mb.schedule(mb)
+	return true
+}
+
+// run runs the queued tasks, at most limit of them when limit is positive,
+// reporting whether some are left.
+func (mb *mailbox) run(limit int) bool {
+	for n := 0; limit <= 0 || n < limit; n++ {
+		mb.lock.Lock()
+		if len(mb.tasks) == 0 {
+			mb.running = false
+			mb.lock.Unlock()
+			return false
+		}
+		task := mb.tasks[0]
+		mb.tasks[0] = nil
+		mb.tasks = mb.tasks[1:]
+		mb.notFull.Signal()
+		mb.lock.Unlock()
+
+		task()
+	}
+
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	if len(mb.tasks) == 0 {
+		mb.running = false
+		return false
+	}
+	return true
+}
+
+// discard drops the queued tasks, returning how many there were.
+func (mb *mailbox) discard() int {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	n := len(mb.tasks)
+	mb.tasks = nil
+	mb.running = false
+	mb.notFull.Broadcast()
+	return n
+}
+
+// close stops blocking the reader. The tasks already queued still run.
+func (mb *mailbox) close() {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	mb.closed = true
+	mb.notFull.Broadcast()
+}
+
+// workerPool runs the mailboxes with queued tasks, one task per turn so that
+// a busy socket does not starve the others.
+type workerPool struct {
+	lock    sync.Mutex
+	ready   *sync.Cond
+	queue   []*mailbox
+	stopped bool
+
+	// dropped is called with the number of tasks discarded once stopped.
+	dropped func(n int)
+}
+
+func newWorkerPool(workers int, dropped func(n int)) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &workerPool{dropped: dropped}
+	p.ready = sync.NewCond(&p.lock)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) schedule(mb *mailbox) {
+	p.lock.Lock()
+	stopped := p.stopped
+	if !stopped {
+		p.queue = append(p.queue, mb)
+		p.ready.Signal()
+	}
+	p.lock.Unlock()
+
+	if stopped {
+		p.dropped(mb.discard())
+	}
+}
+
+func (p *workerPool) work() {
+	for {
+		p.lock.Lock()
+		for len(p.queue) == 0 && !p.stopped {
+			p.ready.Wait()
+		}
+		if p.stopped {
+			p.lock.Unlock()
+			return
+		}
+		mb := p.queue[0]
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		p.lock.Unlock()
+
+		if mb.run(1) {
+			p.schedule(mb)
+		}
+	}
+}
+
+// stop stops the workers once done with their current task. The tasks left
+// in the queue, or queued later, are discarded.
+func (p *workerPool) stop() {
+	p.lock.Lock()
+	p.stopped = true
+	queue := p.queue
+	p.queue = nil
+	p.ready.Broadcast()
+	p.lock.Unlock()
+
+	for _, mb := range queue {
+		p.dropped(mb.discard())
+	}
+}
+
+func (s *Server) newMailbox() *mailbox {
+	switch s.dispatchMode {
+	case dispatchSocket:
+		return newMailbox(s.queueDepth, func(mb *mailbox) {
+			go mb.run(0)
+		})
+	case dispatchPool:
+		return newMailbox(s.queueDepth, s.pool.schedule)
+	}
+	return nil
+}
+
+// enqueue dispatches an event packet according to the server's dispatch
+// mode, counting it as in flight until handled.
+func (s *Socket) enqueue(packet *Packet) {
+	if s.mailbox == nil {
+		s.dispatch(packet)
+		return
+	}
+
+	srv := s.nsp.server
+	srv.inflight.Add(1)
+	ok := s.mailbox.push(func() {
+		defer srv.inflight.Add(-1)
+		s.dispatch(packet)
+	}, srv.overflow)
+	if ok {
+		return
+	}
+	srv.inflight.Add(-1)
+
+	switch srv.overflow {
+	case OverflowDrop:
+		s.logger.Warnf("Queue full, dropping %v", packet)
+	case OverflowDisconnect:
+		s.logger.Warnf("Queue full, disconnecting")
+		s.Disconnect(false)
+	}
+}
@@ -0,0 +1,155 @@
+package socketigo
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/taogames/socket.igo/internal/sockettest"
+)
+
+func TestWorkerPoolStopDiscards(t *testing.T) {
+	dropped := make(chan int, 4)
+	p := newWorkerPool(1, func(n int) { dropped <- n })
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var ran atomic.Int64
+	task := func() { ran.Add(1) }
+
+	busy := newMailbox(4, p.schedule)
+	busy.push(func() {
+		close(started)
+		<-release
+	}, OverflowBlock)
+	<-started
+	busy.push(task, OverflowBlock)
+	busy.push(task, OverflowBlock)
+
+	waiting := newMailbox(4, p.schedule)
+	waiting.push(task, OverflowBlock)
+
+	p.stop()
+	close(release)
+	// Tasks queued after stop are discarded too.
+	late := newMailbox(4, p.schedule)
+	late.push(task, OverflowBlock)
+
+	total := 0
+	deadline := time.After(time.Second)
+	for total < 4 {
+		select {
+		case n := <-dropped:
+			total += n
+		case <-deadline:
+			t.Fatalf("%d tasks discarded, want 4", total)
+		}
+	}
+	if n := ran.Load(); n != 0 {
+		t.Errorf("%d tasks ran after stop", n)
+	}
+}
+
+// newQueueServer connects a client to a server queueing at most one event
+// per socket. The "block" event runs until release is closed, the others are
+// reported on handled.
+func newQueueServer(t *testing.T, policy OverflowPolicy) (c *sockettest.Client, handled chan string, release chan struct{}) {
+	t.Helper()
+	srv, url := newTestServer(t, WithSocketQueues(1, policy))
+	started := make(chan struct{})
+	handled = make(chan string, 8)
+	release = make(chan struct{})
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		socket.On("block", func() {
+			close(started)
+			<-release
+		})
+		socket.On("event", func(name string) {
+			handled <- name
+		})
+	})
+	c = connect(t, url, "/")
+
+	if err := c.Send(`2["block"]`); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("block not handled")
+	}
+	return c, handled, release
+}
+
+func TestOverflowDrop(t *testing.T) {
+	c, handled, release := newQueueServer(t, OverflowDrop)
+	for _, name := range []string{"queued", "dropped", "dropped"} {
+		if err := c.Send(`2["event","` + name + `"]`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+
+	if err := c.Send(`2["event","after"]`); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"queued", "after"} {
+		select {
+		case name := <-handled:
+			if name != want {
+				t.Fatalf("got %s, want %s", name, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not handled", want)
+		}
+	}
+}
+
+func TestOverflowDisconnect(t *testing.T) {
+	c, handled, release := newQueueServer(t, OverflowDisconnect)
+	defer close(release)
+	for _, name := range []string{"queued", "overflow"} {
+		if err := c.Send(`2["event","` + name + `"]`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if packet, err := c.Expect(time.Second); err != nil || packet != "1" {
+		t.Fatalf("got %q, %v, want a DISCONNECT", packet, err)
+	}
+	select {
+	case name := <-handled:
+		t.Errorf("%s handled before release", name)
+	default:
+	}
+}
+
+func TestSocketQueueOrder(t *testing.T) {
+	const n = 50
+
+	srv, url := newTestServer(t, WithSocketQueues(n, OverflowBlock))
+	handled := make(chan int, n)
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		socket.On("event", func(i int) {
+			handled <- i
+		})
+	})
+	c := connect(t, url, "/")
+
+	for i := 0; i < n; i++ {
+		if err := c.Send(`2["event",` + strconv.Itoa(i) + `]`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for want := 0; want < n; want++ {
+		select {
+		case i := <-handled:
+			if i != want {
+				t.Fatalf("got event %d, want %d", i, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d not handled", want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package socketigo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	engineigo "github.com/taogames/engine.igo"
+	"github.com/taogames/engine.igo/message"
+	"github.com/taogames/engine.igo/transport"
+	"github.com/taogames/engine.igo/transport/polling"
+	"github.com/taogames/engine.igo/transport/websocket"
+	"github.com/taogames/engine.igo/utils/idgen"
+	"go.uber.org/zap"
+)
+
+// engineSession is an Engine.IO session along with the request that opened it.
+type engineSession struct {
+	handshake *Handshake
+	session   *engineigo.Session
+	ready     chan struct{}
+}
+
+// engineSessions tracks the sessions of an Engine.IO server. It wraps the id
+// generator to pair each session id with its handshake request: Engine.IO
+// draws the id on the goroutine serving the handshake, so handshakes are let
+// through one at a time, from begin until Accept receives the session.
+//
+// Engine.IO adds the session to a map it does not lock in between, and
+// removes it from there when closed, so closes wait for the handshake in
+// progress.
+type engineSessions struct {
+	gen        idgen.Generator
+	sem        chan struct{}
+	done       <-chan struct{} // Closed once Accept stops receiving sessions
+	transports *transport.Manager
+	logger     *zap.SugaredLogger
+
+	lock     sync.Mutex
+	pending  *Handshake
+	sessions map[string]*engineSession
+}
+
+// engineIDLock serializes the swap of idgen.Default around
+// engineigo.NewServer, which copies the generator it finds there.
+var engineIDLock sync.Mutex
+
+func newEngine(logger *zap.SugaredLogger, done <-chan struct{}, opts ...engineigo.ServerOption) (*engineigo.Server, *engineSessions) {
+	engineIDLock.Lock()
+	defer engineIDLock.Unlock()
+
+	sessions := &engineSessions{
+		gen:  idgen.Default,
+		sem:  make(chan struct{}, 1),
+		done: done,
+		transports: transport.NewManager([]transport.Transport{
+			polling.Default,
+			websocket.Default,
+		}),
+		logger:   logger,
+		sessions: make(map[string]*engineSession),
+	}
+	idgen.Default = sessions
+	defer func() { idgen.Default = sessions.gen }()
+
+	opts = append(opts, engineigo.WithLogger(logger))
+	return engineigo.NewServer(opts...), sessions
+}
+
+// begin waits for the handshake's turn, reporting false once Accept stopped.
+func (es *engineSessions) begin(hs *Handshake) bool {
+	select {
+	case es.sem <- struct{}{}:
+	case <-es.done:
+		return false
+	}
+	es.lock.Lock()
+	es.pending = hs
+	es.lock.Unlock()
+	return true
+}
+
+// end releases the slot taken by begin if Engine.IO rejected the handshake
+// before drawing an id.
+func (es *engineSessions) end(hs *Handshake) {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	if es.pending == hs {
+		es.pending = nil
+		<-es.sem
+	}
+}
+
+func (es *engineSessions) NextID() (string, error) {
+	id, err := es.gen.NextID()
+
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	if es.pending != nil {
+		if err == nil {
+			es.sessions[id] = &engineSession{
+				handshake: es.pending,
+				ready:     make(chan struct{}),
+			}
+		} else {
+			<-es.sem
+		}
+		es.pending = nil
+	}
+	return id, err
+}
+
+// accept registers a session received from Engine.IO and returns its
+// handshake, letting the next one through.
+func (es *engineSessions) accept(sess *engineigo.Session) *Handshake {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	entry, ok := es.sessions[sess.ID()]
+	if !ok {
+		return nil
+	}
+	<-es.sem
+	entry.session = sess
+	close(entry.ready)
+	return entry.handshake
+}
+
+// get returns the session with the given id, waiting for Accept to receive it
+// if the client was quicker.
+func (es *engineSessions) get(ctx context.Context, sid string) (*engineigo.Session, bool) {
+	es.lock.Lock()
+	entry, ok := es.sessions[sid]
+	es.lock.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	select {
+	case <-entry.ready:
+		return entry.session, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+func (es *engineSessions) remove(sid string) {
+	es.lock.Lock()
+	delete(es.sessions, sid)
+	es.lock.Unlock()
+}
+
+// close closes the session once no handshake is in progress. It must not be
+// called from Accept, which the handshake may be waiting for.
+func (es *engineSessions) close(sess *engineigo.Session) {
+	es.remove(sess.ID())
+	select {
+	case es.sem <- struct{}{}:
+		defer func() { <-es.sem }()
+	case <-es.done:
+	}
+	sess.Close()
+}
+
+// serve handles a request for an established session. It mirrors
+// engineigo.Server.ServeHTTP, which reads its session map without a lock.
+func (es *engineSessions) serve(w http.ResponseWriter, r *http.Request, sid string) {
+	query := r.URL.Query()
+	if reqEIO := query.Get("EIO"); reqEIO != engineigo.EIO {
+		errMsg := fmt.Sprintf("invalid EIO=%s", reqEIO)
+		es.logger.Error(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	reqTransportName := query.Get("transport")
+	reqTransport, ok := es.transports.Get(reqTransportName)
+	if !ok {
+		errMsg := fmt.Sprintf("invalid transport=%s", reqTransportName)
+		es.logger.Error(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	sess, ok := es.get(r.Context(), sid)
+	if !ok {
+		errMsg := fmt.Sprintf("session=%v not exist", sid)
+		es.logger.Error(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	if reqTransportName != sess.Transport() {
+		if !es.transports.CanUpgrade(sess.Transport(), reqTransportName) {
+			errMsg := fmt.Sprintf("session=%s cannot upgrade from %s to %s", sid, sess.Transport(), reqTransportName)
+			es.logger.Error(errMsg)
+			http.Error(w, errMsg, http.StatusBadRequest)
+			return
+		}
+		if err := sess.Upgrade(w, r, reqTransport); err != nil {
+			es.logger.Errorf("session=%s upgrade: %s", sid, err.Error())
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+	} else if !sess.Unique(r.Method) {
+		errMsg := fmt.Sprintf("session=%v duplicate method=%v", sid, r.Method)
+		es.logger.Error(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		es.close(sess)
+		return
+	} else {
+		defer sess.UnlockMethod(r.Method)
+	}
+
+	// Engine.IO closes the session on a close packet, out of reach of the
+	// handshakes lock, so close packets sent by polling are taken out of the
+	// payload and the session closed here once the rest is read.
+	if r.Method == http.MethodPost && sess.Transport() == polling.Default.Name() {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			es.logger.Errorf("session=%s read body: %s", sid, err.Error())
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, closing := stripClosePackets(body)
+		if closing {
+			es.remove(sid)
+			defer es.close(sess)
+		}
+		if len(body) == 0 {
+			w.Write([]byte("ok"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if err := sess.ServeHTTP(w, r); err != nil {
+		es.logger.Errorf("session=%s ServeHTTP: %s", sid, err.Error())
+		es.close(sess)
+	}
+}
+
+// stripClosePackets removes the close packets of a polling payload,
+// reporting whether there were any.
+func stripClosePackets(payload []byte) ([]byte, bool) {
+	packets := bytes.Split(payload, []byte{0x1e})
+	kept := packets[:0]
+	for _, p := range packets {
+		if !bytes.Equal(p, message.PTClose.Bytes()) {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == len(packets) {
+		return payload, false
+	}
+	return bytes.Join(kept, []byte{0x1e}), true
+}
@@ -1,37 +1,171 @@
 package socketigo
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"sync"
 )
 
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// EventManager keeps the listeners of each event, called in the order they
+// were registered.
 type EventManager struct {
-	m map[string]*handler
+	lock sync.RWMutex
+	m    map[string][]*Listener
 }
 
-type handler struct {
+// Listener is a registered event handler, the handle to pass to Off.
+type Listener struct {
 	f     reflect.Value
-	types []reflect.Type
+	types []reflect.Type // Without the leading context.Context, if any
+	once  bool
+
+	takesContext bool
+
+	// call replaces f for the typed listeners, see On.
+	call func(s *Socket, packet *Packet, ack func(...interface{})) error
+
+	// any is set instead of f for the catch-all listeners, see OnAny.
+	any AnyListener
 }
 
-func (eh *EventManager) Register(eName string, h any) {
+func newListener(h any, once bool) *Listener {
 	rv := reflect.ValueOf(h)
 	if rv.Kind() != reflect.Func {
 		panic(fmt.Sprintln("reflect kind is ", rv.Kind()))
 	}
 
 	rt := rv.Type()
-	types := make([]reflect.Type, rt.NumIn())
-	for i := 0; i < rt.NumIn(); i++ {
-		types[i] = rt.In(i)
+	if !validResults(rt) {
+		panic(fmt.Sprintln("invalid handler results: ", rt))
+	}
+	first := 0
+	takesContext := rt.NumIn() > 0 && rt.In(0) == contextType
+	if takesContext {
+		first = 1
+	}
+	types := make([]reflect.Type, 0, rt.NumIn()-first)
+	for i := first; i < rt.NumIn(); i++ {
+		types = append(types, rt.In(i))
+	}
+
+	return &Listener{
+		f:            rv,
+		types:        types,
+		once:         once,
+		takesContext: takesContext,
+	}
+}
+
+// takesAck reports whether the listener expects an ack function after its n
+// parsed arguments.
+func (l *Listener) takesAck(n int) bool {
+	return !l.f.Type().IsVariadic() && len(l.types) > n && l.types[len(l.types)-1] == ackFuncType
+}
+
+// pad completes the parsed arguments with zero values, up to the ack
+// function if the listener takes one.
+func (l *Listener) pad(args []reflect.Value) []reflect.Value {
+	if l.f.Type().IsVariadic() {
+		return args
+	}
+	n := len(l.types)
+	if n > 0 && l.types[n-1] == ackFuncType {
+		n--
+	}
+	for len(args) < n {
+		args = append(args, reflect.Zero(l.types[len(args)]))
+	}
+	return args
+}
+
+// withContext prepends ctx to the arguments if the listener takes it.
+func (l *Listener) withContext(ctx context.Context, args []reflect.Value) []reflect.Value {
+	if !l.takesContext {
+		return args
 	}
+	return append([]reflect.Value{reflect.ValueOf(&ctx).Elem()}, args...)
+}
+
+func (eh *EventManager) Register(eName string, h any) *Listener {
+	return eh.add(eName, newListener(h, false))
+}
+
+// RegisterOnce registers a listener removed the first time the event fires.
+func (eh *EventManager) RegisterOnce(eName string, h any) *Listener {
+	return eh.add(eName, newListener(h, true))
+}
+
+func (eh *EventManager) add(eName string, l *Listener) *Listener {
+	eh.lock.Lock()
+	defer eh.lock.Unlock()
+	eh.m[eName] = append(eh.m[eName], l)
+	return l
+}
+
+// Remove removes a listener, reporting whether it was registered.
+func (eh *EventManager) Remove(eName string, l *Listener) bool {
+	eh.lock.Lock()
+	defer eh.lock.Unlock()
+
+	listeners := eh.m[eName]
+	for i, listener := range listeners {
+		if listener != l {
+			continue
+		}
+		listeners = append(listeners[:i:i], listeners[i+1:]...)
+		if len(listeners) == 0 {
+			delete(eh.m, eName)
+		} else {
+			eh.m[eName] = listeners
+		}
+		return true
+	}
+	return false
+}
+
+// RemoveAll removes the listeners of the given events, or of every event
+// when none is given.
+func (eh *EventManager) RemoveAll(eNames ...string) {
+	eh.lock.Lock()
+	defer eh.lock.Unlock()
 
-	eh.m[eName] = &handler{
-		f:     rv,
-		types: types,
+	if len(eNames) == 0 {
+		eh.m = make(map[string][]*Listener)
+		return
+	}
+	for _, eName := range eNames {
+		delete(eh.m, eName)
 	}
 }
 
-func (eh *EventManager) GetHandler(eName string) *handler {
-	return eh.m[eName]
+func (eh *EventManager) Count(eName string) int {
+	eh.lock.RLock()
+	defer eh.lock.RUnlock()
+	return len(eh.m[eName])
+}
+
+// fire returns the listeners to call for an event, removing the ones
+// registered with RegisterOnce.
+func (eh *EventManager) fire(eName string) []*Listener {
+	eh.lock.Lock()
+	defer eh.lock.Unlock()
+
+	listeners := eh.m[eName]
+	kept := listeners[:0:0]
+	for _, l := range listeners {
+		if !l.once {
+			kept = append(kept, l)
+		}
+	}
+	if len(kept) != len(listeners) {
+		if len(kept) == 0 {
+			delete(eh.m, eName)
+		} else {
+			eh.m[eName] = kept
+		}
+	}
+	return listeners
 }
@@ -0,0 +1,100 @@
+package socketigo
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"go.uber.org/zap"
+)
+
+// The categories of the errors passed to the OnError hook, to be tested with
+// errors.Is.
+var (
+	ErrDecode       = errors.New("decode error")
+	ErrUnknownEvent = errors.New("unknown event")
+	ErrHandlerPanic = errors.New("handler panic")
+)
+
+// ErrorHandler is called when an event cannot be handled. The panics of the
+// other handlers are reported with the event "connection" for the middlewares
+// and OnConnection, "disconnect" for OnDisconnect, and the name of the
+// emitted event for ack callbacks. socket is nil for server side events.
+type ErrorHandler func(socket *Socket, event string, err error)
+
+// Events reported to the OnError hook for the handlers of the socket
+// lifecycle.
+const (
+	EventConnection = "connection"
+	EventDisconnect = "disconnect"
+)
+
+// OnError registers the hook called when an event fails to decode, has no
+// listener or makes its handler panic. Errors are only logged by default.
+func (s *Server) OnError(f ErrorHandler) {
+	s.onError = f
+}
+
+// WithErrorEvent makes the server also emit the errors of OnError to the
+// client, as an event with the name of the failed event and the payload of
+// the ErrorMapper.
+func WithErrorEvent(eName string) ServerOption {
+	return func(s *Server) {
+		s.errorEvent = eName
+	}
+}
+
+// invoke runs a handler, turning its panic into an error.
+func invoke(logger *zap.SugaredLogger, f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Handler panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+		}
+	}()
+	return f()
+}
+
+func (s *Socket) invoke(f func() error) error {
+	return invoke(s.logger, f)
+}
+
+// reportError passes an error to the hook, or logs it.
+func (srv *Server) reportError(logger *zap.SugaredLogger, socket *Socket, event string, err error) {
+	if srv.onError == nil {
+		logger.Errorf("Event %s: %v", event, err)
+		return
+	}
+	invoke(logger, func() error {
+		srv.onError(socket, event, err)
+		return nil
+	})
+}
+
+// guard runs a handler of the socket lifecycle, reporting its panic.
+func (s *Socket) guard(event string, f func()) {
+	if err := s.invoke(func() error {
+		f()
+		return nil
+	}); err != nil {
+		s.nsp.server.reportError(s.logger, s, event, err)
+	}
+}
+
+// handleError reports an error to the hook and the client, acknowledging
+// the event with it if the client expects an acknowledgement.
+func (s *Socket) handleError(event string, err error, ack func(...interface{})) {
+	srv := s.nsp.server
+	srv.reportError(s.logger, s, event, err)
+
+	if ack == nil && srv.errorEvent == "" {
+		return
+	}
+	payload := srv.errorMapper(err)
+	if ack != nil {
+		ack(payload)
+	}
+	if srv.errorEvent != "" {
+		s.Emit(srv.errorEvent, event, payload)
+	}
+}
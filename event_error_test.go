@@ -0,0 +1,80 @@
+package socketigo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type report struct {
+	socket *Socket
+	event  string
+	err    error
+}
+
+func expectReport(t *testing.T, reports chan report, event string, withSocket bool) {
+	t.Helper()
+	select {
+	case r := <-reports:
+		if r.event != event || (r.socket != nil) != withSocket || !errors.Is(r.err, ErrHandlerPanic) {
+			t.Errorf("got %+v, want a panic of %s", r, event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no report for %s", event)
+	}
+}
+
+func TestHandlerPanics(t *testing.T) {
+	srv, url := newTestServer(t)
+	reports := make(chan report, 8)
+	srv.OnError(func(socket *Socket, event string, err error) {
+		reports <- report{socket, event, err}
+	})
+
+	srv.Of("/guarded").Use(func(*Socket, func(error)) {
+		panic("middleware")
+	})
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		socket.OnDisconnect(func(DisconnectReason) {
+			panic("disconnect")
+		})
+		socket.EmitWithCallback(context.Background(), "ask", func(*AckResponse, error) {
+			panic("ack")
+		})
+		panic("connection")
+	})
+	srv.Of("/").OnServerSideEmit("event", func() {
+		panic("server side")
+	})
+	c := dial(t, url)
+
+	reply, err := c.Connect("/guarded")
+	if err != nil || !strings.HasPrefix(reply, "4/guarded,") {
+		t.Fatalf("got %q, %v, want a CONNECT_ERROR", reply, err)
+	}
+	expectReport(t, reports, EventConnection, true)
+
+	if reply, err := c.Connect("/"); err != nil || !strings.HasPrefix(reply, "0{") {
+		t.Fatalf("got %q, %v", reply, err)
+	}
+	ask, err := c.Expect(time.Second)
+	if err != nil || ask != `20["ask"]` {
+		t.Fatalf("got %q, %v", ask, err)
+	}
+	expectReport(t, reports, EventConnection, true)
+
+	if err := c.Send(`30["ok"]`); err != nil {
+		t.Fatal(err)
+	}
+	expectReport(t, reports, "ask", true)
+
+	if err := c.Send(`1`); err != nil {
+		t.Fatal(err)
+	}
+	expectReport(t, reports, EventDisconnect, true)
+
+	srv.Of("/").HandleServerSideEmit([]interface{}{"event"}, nil)
+	expectReport(t, reports, "event", false)
+}
@@ -28,12 +28,15 @@ func main() {
 		panic(err)
 	}
 
-	server := socketigo.NewServer(
+	server, err := socketigo.NewServer(
 		socketigo.WithPingInterval(time.Millisecond*3000),
 		socketigo.WithPingTimeout(time.Millisecond*2000),
 		socketigo.WithMaxPayload(10000),
 		socketigo.WithLogger(logger.Sugar()),
 	)
+	if err != nil {
+		panic(err)
+	}
 
 	server.Of("/").OnConnection(func(socket *socketigo.Socket) {
 		socket.On("ack", func(para string, ack func(...interface{})) {
@@ -10,7 +10,10 @@ import (
 var numUsers int
 
 func main() {
-	server := socketigo.NewServer()
+	server, err := socketigo.NewServer()
+	if err != nil {
+		panic(err)
+	}
 	server.Of("/").OnConnection(func(socket *socketigo.Socket) {
 		addedUser := false
 		socket.On("add user", func(username string) {
@@ -18,7 +21,7 @@ func main() {
 				return
 			}
 
-			socket.Custom["username"] = username
+			socket.SetCustom("username", username)
 			numUsers++
 			addedUser = true
 
@@ -32,7 +35,7 @@ func main() {
 				Username string `json:"username"`
 				NumUsers int    `json:"numUsers"`
 			}{
-				Username: socket.Custom["username"].(string),
+				Username: usernameOf(socket),
 				NumUsers: numUsers,
 			})
 		})
@@ -42,7 +45,7 @@ func main() {
 				Username string `json:"username"`
 				Message  string `json:"message"`
 			}{
-				Username: socket.Custom["username"].(string),
+				Username: usernameOf(socket),
 				Message:  data,
 			})
 		})
@@ -51,7 +54,7 @@ func main() {
 			socket.Broadcast().Emit("typing", struct {
 				Username string `json:"username"`
 			}{
-				Username: socket.Custom["username"].(string),
+				Username: usernameOf(socket),
 			})
 		})
 
@@ -59,7 +62,7 @@ func main() {
 			socket.Broadcast().Emit("stop typing", struct {
 				Username string `json:"username"`
 			}{
-				Username: socket.Custom["username"].(string),
+				Username: usernameOf(socket),
 			})
 		})
 
@@ -72,7 +75,7 @@ func main() {
 				Username string `json:"username"`
 				NumUsers int    `json:"numUsers"`
 			}{
-				Username: socket.Custom["username"].(string),
+				Username: usernameOf(socket),
 				NumUsers: numUsers,
 			})
 		})
@@ -88,3 +91,8 @@ func main() {
 		panic(err)
 	}
 }
+
+func usernameOf(socket *socketigo.Socket) string {
+	name, _ := socket.GetCustom("username")
+	return name.(string)
+}
@@ -9,7 +9,10 @@ import (
 var numUsers int
 
 func main() {
-	server := socketigo.NewServer()
+	server, err := socketigo.NewServer()
+	if err != nil {
+		panic(err)
+	}
 	server.Of("/").OnConnection(func(socket *socketigo.Socket) {
 		addedUser := false
 		socket.On("add user", func(username string) {
@@ -17,7 +20,7 @@ func main() {
 				return
 			}
 
-			socket.Custom["username"] = username
+			socket.SetCustom("username", username)
 			numUsers++
 			addedUser = true
 
@@ -31,7 +34,7 @@ func main() {
 				Username string `json:"username"`
 				NumUsers int    `json:"numUsers"`
 			}{
-				Username: socket.Custom["username"].(string),
+				Username: usernameOf(socket),
 				NumUsers: numUsers,
 			})
 		})
@@ -41,7 +44,7 @@ func main() {
 				Username string `json:"username"`
 				Message  string `json:"message"`
 			}{
-				Username: socket.Custom["username"].(string),
+				Username: usernameOf(socket),
 				Message:  data,
 			})
 		})
@@ -50,7 +53,7 @@ func main() {
 			socket.Broadcast().Emit("typing", struct {
 				Username string `json:"username"`
 			}{
-				Username: socket.Custom["username"].(string),
+				Username: usernameOf(socket),
 			})
 		})
 
@@ -58,7 +61,7 @@ func main() {
 			socket.Broadcast().Emit("stop typing", struct {
 				Username string `json:"username"`
 			}{
-				Username: socket.Custom["username"].(string),
+				Username: usernameOf(socket),
 			})
 		})
 
@@ -71,7 +74,7 @@ func main() {
 				Username string `json:"username"`
 				NumUsers int    `json:"numUsers"`
 			}{
-				Username: socket.Custom["username"].(string),
+				Username: usernameOf(socket),
 				NumUsers: numUsers,
 			})
 		})
@@ -86,3 +89,8 @@ func main() {
 		panic(err)
 	}
 }
+
+func usernameOf(socket *socketigo.Socket) string {
+	name, _ := socket.GetCustom("username")
+	return name.(string)
+}
@@ -32,12 +32,15 @@ func main() {
 		panic(err)
 	}
 
-	server := socketigo.NewServer(
+	server, err := socketigo.NewServer(
 		socketigo.WithPingInterval(time.Millisecond*300),
 		socketigo.WithPingTimeout(time.Millisecond*200),
 		socketigo.WithMaxPayload(1000000),
 		socketigo.WithLogger(logger.Sugar()),
 	)
+	if err != nil {
+		panic(err)
+	}
 
 	server.Of("/").OnConnection(func(socket *socketigo.Socket) {
 
@@ -0,0 +1,115 @@
+package socketigo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+// HandlerError can be returned by a handler to acknowledge the event with an
+// error code for the client.
+type HandlerError struct {
+	Code    string
+	Message string
+}
+
+func (e *HandlerError) Error() string {
+	return e.Message
+}
+
+// ErrorMapper turns the error returned by a handler into the payload the
+// event is acknowledged with.
+type ErrorMapper func(err error) interface{}
+
+// WithErrorMapper replaces DefaultErrorMapper.
+func WithErrorMapper(mapper ErrorMapper) ServerOption {
+	return func(s *Server) {
+		s.errorMapper = mapper
+	}
+}
+
+type errorEnvelope struct {
+	Error errorPayload `json:"error"`
+}
+
+type errorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// The codes of DefaultErrorMapper for the errors that are not a
+// HandlerError.
+const (
+	CodeDecodeError  = "decode_error"
+	CodeUnknownEvent = "unknown_event"
+	CodeInternal     = "internal"
+)
+
+// DefaultErrorMapper acknowledges with {"error": {"code", "message"}},
+// hiding the details of the handler panics.
+func DefaultErrorMapper(err error) interface{} {
+	var he *HandlerError
+	switch {
+	case errors.As(err, &he):
+		return errorEnvelope{Error: errorPayload{Code: he.Code, Message: he.Message}}
+	case errors.Is(err, ErrDecode):
+		return errorEnvelope{Error: errorPayload{Code: CodeDecodeError, Message: err.Error()}}
+	case errors.Is(err, ErrUnknownEvent):
+		return errorEnvelope{Error: errorPayload{Code: CodeUnknownEvent, Message: err.Error()}}
+	case errors.Is(err, ErrHandlerPanic):
+		return errorEnvelope{Error: errorPayload{Code: CodeInternal, Message: ErrHandlerPanic.Error()}}
+	}
+	return errorEnvelope{Error: errorPayload{Code: CodeInternal, Message: err.Error()}}
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// validResults reports whether a handler returns nothing, a response, an
+// error, or a response and an error.
+func validResults(rt reflect.Type) bool {
+	switch rt.NumOut() {
+	case 0, 1:
+		return true
+	case 2:
+		return rt.Out(1) == errorType
+	}
+	return false
+}
+
+// ackArgs returns the arguments to acknowledge an event with, from the
+// values returned by its handler.
+func (s *Server) ackArgs(results []reflect.Value) []interface{} {
+	last := results[len(results)-1]
+	if last.Type() == errorType {
+		if !last.IsNil() {
+			return []interface{}{s.errorMapper(last.Interface().(error))}
+		}
+		results = results[:len(results)-1]
+	}
+
+	args := make([]interface{}, len(results))
+	for i := range results {
+		args[i] = results[i].Interface()
+	}
+	return args
+}
+
+// Handle is like OnAck for handlers that may fail. The acknowledgement is
+// built like the one of a Socket.On handler returning (Resp, error), the
+// error being mapped by the server's ErrorMapper.
+func Handle[Req, Resp any](s *Socket, eName string, f func(ctx context.Context, req Req) (Resp, error)) *Listener {
+	return s.eh.add(eName, &Listener{
+		call: func(s *Socket, packet *Packet, ack func(...interface{})) error {
+			var req Req
+			if err := decodeEventArgs(s.conn.parser, packet, &req); err != nil {
+				return err
+			}
+			resp, err := f(s.Context(), req)
+			ack(s.nsp.server.ackArgs([]reflect.Value{
+				reflect.ValueOf(&resp).Elem(),
+				reflect.ValueOf(&err).Elem(),
+			})...)
+			return nil
+		},
+	})
+}
@@ -0,0 +1,53 @@
+package socketigo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func half(n int) (int, error) {
+	if n%2 != 0 {
+		return 0, &HandlerError{Code: "odd", Message: "odd number"}
+	}
+	if n < 0 {
+		return 0, errors.New("negative number")
+	}
+	return n / 2, nil
+}
+
+// Handle acknowledges like a Socket.On handler with the same results.
+func TestHandleAcks(t *testing.T) {
+	srv, url := newTestServer(t)
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		socket.On("on", half)
+		Handle(socket, "handle", func(ctx context.Context, n int) (int, error) {
+			return half(n)
+		})
+	})
+	c := connect(t, url, "/")
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"4", `[2]`},
+		{"3", `[{"error":{"code":"odd","message":"odd number"}}]`},
+		{"-2", `[{"error":{"code":"internal","message":"negative number"}}]`},
+	}
+	for _, tt := range tests {
+		for _, event := range []string{"on", "handle"} {
+			if err := c.Send(`21["` + event + `",` + tt.arg + `]`); err != nil {
+				t.Fatal(err)
+			}
+			ack, err := c.Expect(time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ack != "31"+tt.want {
+				t.Errorf("%s(%s): got %s, want 31%s", event, tt.arg, ack, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package socketigo
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Handshake holds the details of the HTTP request that opened the
+// connection, along with the auth payload of the namespace CONNECT packet.
+type Handshake struct {
+	Headers http.Header            `json:"headers"`
+	Time    string                 `json:"time"`
+	Address string                 `json:"address"`
+	XDomain bool                   `json:"xdomain"`
+	Secure  bool                   `json:"secure"`
+	Issued  int64                  `json:"issued"` // Milliseconds since epoch
+	Url     string                 `json:"url"`
+	Query   url.Values             `json:"query"`
+	Auth    map[string]interface{} `json:"auth"`
+}
+
+func newHandshake(r *http.Request) *Handshake {
+	now := time.Now()
+
+	address := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+
+	return &Handshake{
+		Headers: r.Header.Clone(),
+		Time:    now.Format(time.RFC1123Z),
+		Address: address,
+		XDomain: r.Header.Get("Origin") != "",
+		Secure:  r.TLS != nil,
+		Issued:  now.UnixMilli(),
+		Url:     r.URL.RequestURI(),
+		Query:   r.URL.Query(),
+	}
+}
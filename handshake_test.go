@@ -0,0 +1,57 @@
+package socketigo
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandshake(t *testing.T) {
+	srv, base := newTestServer(t)
+	handshakes := make(chan Handshake, 1)
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		handshakes <- socket.Handshake
+	})
+	url := base + "/socket.io/?EIO=4&transport=polling&token=abc"
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Origin", "http://example.com")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	var open struct {
+		Sid string `json:"sid"`
+	}
+	if err := json.Unmarshal(body[1:], &open); err != nil {
+		t.Fatalf("open packet %q: %v", body, err)
+	}
+
+	// The CONNECT reply is written to a pending poll.
+	go func() {
+		if resp, err := http.Get(url + "&sid=" + open.Sid); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	resp, err = http.Post(url+"&sid="+open.Sid, "text/plain", strings.NewReader(`40{"key":"value"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	select {
+	case hs := <-handshakes:
+		if hs.Query.Get("token") != "abc" || hs.Headers.Get("Origin") != "http://example.com" || !hs.XDomain {
+			t.Errorf("got %+v", hs)
+		}
+		if hs.Address != "127.0.0.1" || !strings.HasPrefix(hs.Url, "/socket.io/?") || hs.Auth["key"] != "value" {
+			t.Errorf("got %+v", hs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no connection")
+	}
+}
@@ -0,0 +1,48 @@
+package socketigo
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/taogames/socket.igo/internal/sockettest"
+	"go.uber.org/zap"
+)
+
+// newTestServer starts a server with a silent logger behind an HTTP test
+// server, both closed with the test, and returns it along with its URL.
+func newTestServer(t *testing.T, opts ...ServerOption) (*Server, string) {
+	t.Helper()
+	srv, err := NewServer(append([]ServerOption{WithLogger(zap.NewNop().Sugar())}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Accept()
+	ts := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		srv.Close()
+		ts.Close()
+	})
+	return srv, ts.URL
+}
+
+// dial opens a client session, closed with the test.
+func dial(t *testing.T, url string) *sockettest.Client {
+	t.Helper()
+	c, err := sockettest.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// connect dials a client connected to the namespace nsp.
+func connect(t *testing.T, url, nsp string) *sockettest.Client {
+	t.Helper()
+	c := dial(t, url)
+	if reply, err := c.Connect(nsp); err != nil || !strings.HasPrefix(reply, "0") {
+		t.Fatalf("connect to %s: %q, %v", nsp, reply, err)
+	}
+	return c
+}
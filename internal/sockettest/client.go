@@ -0,0 +1,182 @@
+// Package sockettest provides a minimal Socket.IO client for tests, speaking
+// the default parser over HTTP long-polling.
+package sockettest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrTimeout = errors.New("timed out waiting for a packet")
+
+// IDGenerator numbers the Engine.IO sessions. Tests set it as
+// idgen.Default, whose generator needs a private IP address.
+type IDGenerator struct {
+	n atomic.Int64
+}
+
+func (g *IDGenerator) NextID() (string, error) {
+	return strconv.FormatInt(g.n.Add(1), 10), nil
+}
+
+type Client struct {
+	url    string
+	sendMu sync.Mutex
+
+	packets chan string
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// Dial opens an Engine.IO session on the server at baseURL, e.g. the URL of
+// an httptest.Server.
+func Dial(baseURL string) (*Client, error) {
+	return DialHeader(baseURL, nil)
+}
+
+// DialHeader is like Dial, sending header along with the handshake request.
+func DialHeader(baseURL string, header http.Header) (*Client, error) {
+	url := baseURL + "/socket.io/?EIO=4&transport=polling"
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	body, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(body, "0") {
+		return nil, fmt.Errorf("unexpected open packet %q", body)
+	}
+	var open struct {
+		Sid string `json:"sid"`
+	}
+	if err := json.Unmarshal([]byte(body[1:]), &open); err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		url:     url + "&sid=" + open.Sid,
+		packets: make(chan string, 64),
+		closed:  make(chan struct{}),
+	}
+	go c.poll()
+	return c, nil
+}
+
+func get(url string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	return do(req)
+}
+
+func do(req *http.Request) (string, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s %s", req.URL, resp.Status, bs)
+	}
+	return string(bs), nil
+}
+
+func (c *Client) poll() {
+	defer c.Close()
+	for {
+		body, err := get(c.url)
+		if err != nil {
+			return
+		}
+		switch {
+		case body == "1":
+			return
+		case body == "2":
+			if err := c.post("3"); err != nil {
+				return
+			}
+		case strings.HasPrefix(body, "4"):
+			select {
+			case c.packets <- body[1:]:
+			case <-c.closed:
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) post(body string) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	resp, err := http.Post(c.url, "text/plain;charset=UTF-8", bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		bs, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("POST %s: %s %s", c.url, resp.Status, bs)
+	}
+	return nil
+}
+
+// Send sends an encoded Socket.IO packet, e.g. `2["hello"]`.
+func (c *Client) Send(packet string) error {
+	return c.post("4" + packet)
+}
+
+// SendBinary sends a binary attachment. Engine.IO reads the bytes following
+// the "b" prefix of the polling payload as they are.
+func (c *Client) SendBinary(data []byte) error {
+	return c.post("b" + string(data))
+}
+
+// Connect connects to a namespace, returning the CONNECT reply.
+func (c *Client) Connect(nsp string) (string, error) {
+	packet := "0"
+	if nsp != "/" {
+		packet += nsp + ","
+	}
+	if err := c.Send(packet); err != nil {
+		return "", err
+	}
+	return c.Expect(time.Second)
+}
+
+// Expect returns the next packet received.
+func (c *Client) Expect(timeout time.Duration) (string, error) {
+	select {
+	case packet := <-c.packets:
+		return packet, nil
+	case <-time.After(timeout):
+		return "", ErrTimeout
+	}
+}
+
+// Close closes the Engine.IO session.
+func (c *Client) Close() {
+	c.once.Do(func() {
+		close(c.closed)
+		c.post("1")
+	})
+}
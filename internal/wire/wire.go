@@ -0,0 +1,149 @@
+// Package wire holds the packet and broadcast option formats the distributed
+// adapters exchange between servers, as used by the Node.js adapters.
+package wire
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	socketigo "github.com/taogames/socket.igo"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type Packet struct {
+	Type int         `json:"type"`
+	Nsp  string      `json:"nsp"`
+	Data interface{} `json:"data,omitempty"`
+	Id   *int        `json:"id,omitempty"`
+}
+
+type Flags struct {
+	Timeout int64 `json:"timeout,omitempty"` // Milliseconds
+}
+
+type Options struct {
+	Rooms  []string `json:"rooms"`
+	Except []string `json:"except"`
+	Flags  Flags    `json:"flags"`
+}
+
+const structTag = "json"
+
+func Marshal(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	enc := msgpack.NewEncoder(&buffer)
+	enc.SetCustomStructTag(structTag)
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func NewDecoder(msg []byte) *msgpack.Decoder {
+	dec := msgpack.NewDecoder(bytes.NewReader(msg))
+	dec.SetCustomStructTag(structTag)
+	return dec
+}
+
+func NewUid() string {
+	bs := make([]byte, 8)
+	if _, err := rand.Read(bs); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bs)
+}
+
+// Normalize turns the json.Number values left by the default parser into
+// numbers, which MessagePack would otherwise encode as strings.
+func Normalize(v interface{}) interface{} {
+	switch d := v.(type) {
+	case json.Number:
+		if i, err := d.Int64(); err == nil {
+			return i
+		}
+		f, _ := d.Float64()
+		return f
+	case []interface{}:
+		out := make([]interface{}, len(d))
+		for i := range d {
+			out[i] = Normalize(d[i])
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(d))
+		for k := range d {
+			out[k] = Normalize(d[k])
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func FromPacket(packet *socketigo.Packet) *Packet {
+	return &Packet{
+		Type: int(packet.Type),
+		Nsp:  packet.Namespace,
+		Data: Normalize(packet.Data),
+		Id:   packet.Id,
+	}
+}
+
+func (p *Packet) ToPacket() *socketigo.Packet {
+	pt := socketigo.PacketType(p.Type)
+	switch pt {
+	case socketigo.PacketBinaryEvent:
+		pt = socketigo.PacketEvent
+	case socketigo.PacketBinaryAck:
+		pt = socketigo.PacketAck
+	}
+
+	return &socketigo.Packet{
+		Type:      pt,
+		Namespace: p.Nsp,
+		Data:      p.Data,
+		Id:        p.Id,
+	}
+}
+
+// FromOptions converts the options to their wire format, reporting false
+// when no socket can match them.
+func FromOptions(opts *socketigo.BroadcastOptions) (*Options, bool) {
+	raw := &Options{
+		Rooms:  []string{},
+		Except: make([]string, 0, len(opts.Excludes)),
+		Flags: Flags{
+			Timeout: opts.Timeout.Milliseconds(),
+		},
+	}
+	for room := range opts.Excludes {
+		raw.Except = append(raw.Except, room)
+	}
+
+	if !opts.IncludeAll {
+		if len(opts.Includes) == 0 {
+			return nil, false
+		}
+		raw.Rooms = opts.Includes
+	}
+
+	return raw, true
+}
+
+func (o *Options) ToOptions() *socketigo.BroadcastOptions {
+	opts := &socketigo.BroadcastOptions{
+		IncludeAll: len(o.Rooms) == 0,
+		Includes:   o.Rooms,
+		Excludes:   make(map[string]struct{}, len(o.Except)),
+		Timeout:    time.Duration(o.Flags.Timeout) * time.Millisecond,
+	}
+	for _, room := range o.Except {
+		opts.Excludes[room] = struct{}{}
+	}
+	return opts
+}
@@ -0,0 +1,14 @@
+package socketigo
+
+import (
+	"os"
+	"testing"
+
+	"github.com/taogames/engine.igo/utils/idgen"
+	"github.com/taogames/socket.igo/internal/sockettest"
+)
+
+func TestMain(m *testing.M) {
+	idgen.Default = &sockettest.IDGenerator{}
+	os.Exit(m.Run())
+}
@@ -1,8 +1,10 @@
 package socketigo
 
 import (
+	"context"
 	"encoding/json"
 	"sync"
+	"sync/atomic"
 
 	"go.uber.org/zap"
 )
@@ -10,26 +12,40 @@ import (
 const MainNamespace = "/"
 
 type Namespace struct {
+	server  *Server
 	name    string
 	parser  Parser
 	adapter Adapter
+	parent  *ParentNamespace
 
 	onConnection SocketFunction
+	serverSideEh EventManager
+
+	ids atomic.Int64
 
 	sync.RWMutex
 	sockets map[string]*Socket
+	fns     []MiddlewareFunction
 
 	logger *zap.SugaredLogger
 }
 
 type SocketFunction func(*Socket)
 
+// MiddlewareFunction runs before a socket is connected. Calling next with a
+// non-nil error rejects the connection with a CONNECT_ERROR packet.
+type MiddlewareFunction func(socket *Socket, next func(error))
+
 func NewNamespace(s *Server, name string) *Namespace {
 	nsp := &Namespace{
+		server:  s,
 		name:    name,
 		parser:  s.parser,
 		sockets: make(map[string]*Socket),
-		logger:  s.logger.With("Namespace", name),
+		serverSideEh: EventManager{
+			m: make(map[string][]*Listener),
+		},
+		logger: s.logger.With("Namespace", name),
 	}
 	nsp.adapter = s.adapterInit(nsp)
 
@@ -40,22 +56,92 @@ func (nsp *Namespace) OnConnection(f SocketFunction) {
 	nsp.onConnection = f
 }
 
+func (nsp *Namespace) connectionHandler() SocketFunction {
+	if nsp.onConnection == nil && nsp.parent != nil {
+		return nsp.parent.connectionHandler()
+	}
+	return nsp.onConnection
+}
+
+func (nsp *Namespace) Use(f MiddlewareFunction) {
+	nsp.Lock()
+	defer nsp.Unlock()
+	nsp.fns = append(nsp.fns, f)
+}
+
+func (nsp *Namespace) middlewares() []MiddlewareFunction {
+	var fns []MiddlewareFunction
+	if nsp.parent != nil {
+		fns = nsp.parent.middlewares()
+	}
+
+	nsp.RLock()
+	defer nsp.RUnlock()
+	return append(fns, nsp.fns...)
+}
+
+func (nsp *Namespace) run(socket *Socket, fn func(error)) {
+	fns := nsp.middlewares()
+
+	var step func(i int)
+	step = func(i int) {
+		if i == len(fns) {
+			fn(nil)
+			return
+		}
+		// A panicking middleware rejects the socket, unless it called next
+		// first.
+		var called atomic.Bool
+		err := socket.invoke(func() error {
+			fns[i](socket, func(err error) {
+				if !called.CompareAndSwap(false, true) {
+					return
+				}
+				if err != nil {
+					fn(err)
+					return
+				}
+				step(i + 1)
+			})
+			return nil
+		})
+		if err != nil {
+			nsp.server.reportError(socket.logger, socket, EventConnection, err)
+			if called.CompareAndSwap(false, true) {
+				fn(ErrHandlerPanic)
+			}
+		}
+	}
+	step(0)
+}
+
+// Parent returns the parent namespace this namespace was created from, if any.
+func (nsp *Namespace) Parent() *ParentNamespace {
+	return nsp.parent
+}
+
 func (nsp *Namespace) Name() string {
 	return nsp.name
 }
 
-func (nsp *Namespace) Connect(sid string, conn *Connection, handshake []byte) {
+// Connect creates a socket for the connection, with an id of its own, and
+// runs the middlewares before connecting it.
+func (nsp *Namespace) Connect(conn *Connection, handshake []byte) {
+	sid := generateId()
 	socket := &Socket{
 		Id:   sid,
 		conn: conn,
 		nsp:  nsp,
 		eh: EventManager{
-			m: make(map[string]*handler),
+			m: make(map[string][]*Listener),
 		},
-		Custom: make(map[string]interface{}),
-		logger: nsp.logger.With("Socket", sid),
+		acks:    make(map[int]*pendingAck),
+		custom:  make(map[string]interface{}),
+		mailbox: nsp.server.newMailbox(),
+		logger:  nsp.logger.With("Socket", sid),
 	}
-	socket.connected.Store(true)
+	socket.ctx, socket.cancel = context.WithCancelCause(context.Background())
+	socket.Handshake = *conn.handshake
 	socket.Handshake.Auth = make(map[string]interface{})
 	if len(handshake) > 0 {
 		if err := json.Unmarshal([]byte(handshake), &socket.Handshake.Auth); err != nil {
@@ -63,15 +149,151 @@ func (nsp *Namespace) Connect(sid string, conn *Connection, handshake []byte) {
 		}
 	}
 
-	conn.socketIds[nsp.name] = socket
+	if nsp.server.recoveryDuration > 0 {
+		if nsp.recover(socket) {
+			nsp.doConnect(socket)
+			return
+		}
+		socket.pid = generateId()
+	}
+
+	nsp.run(socket, func(err error) {
+		if err != nil {
+			nsp.logger.Debugf("Middleware rejected socket %s: %v", sid, err)
+			socket.cancel(err)
+			conn.ConnectError(nsp.name, newErrMsg(err))
+			return
+		}
+		nsp.doConnect(socket)
+	})
+}
+
+// recover restores the session matching the pid and offset sent in the
+// CONNECT auth payload, if any.
+func (nsp *Namespace) recover(socket *Socket) bool {
+	pid, _ := socket.Handshake.Auth["pid"].(string)
+	offset, _ := socket.Handshake.Auth["offset"].(string)
+	if pid == "" || offset == "" {
+		return false
+	}
+
+	session, err := nsp.adapter.RestoreSession(pid, offset)
+	if err != nil {
+		nsp.logger.Errorf("RestoreSession %s: %v", pid, err)
+		return false
+	}
+	if session == nil {
+		return false
+	}
+
+	socket.Id = session.Sid
+	socket.pid = session.Pid
+	socket.custom = session.Data
+	if socket.custom == nil {
+		socket.custom = make(map[string]interface{})
+	}
+	socket.recovered = session
+	socket.logger = nsp.logger.With("Socket", socket.Id)
+	return true
+}
+
+func (nsp *Namespace) doConnect(socket *Socket) {
+	conn := socket.conn
+	// Another CONNECT may have gone through the middlewares first.
+	if !conn.addSocket(socket) {
+		socket.cancel(ErrSocketDisconnected)
+		conn.ConnectError(nsp.name, ErrAlreadyConnected)
+		return
+	}
+	conn.writeConnect(nsp, socket)
+
+	socket.connected.Store(true)
 	nsp.Lock()
 	nsp.sockets[socket.Id] = socket
 	nsp.Unlock()
 
-	if nsp.onConnection != nil {
-		nsp.onConnection(socket)
-	}
 	nsp.adapter.Join(socket.Id, socket.Id)
+	if session := socket.recovered; session != nil {
+		nsp.adapter.Join(socket.Id, session.Rooms...)
+		for _, data := range session.MissedPackets {
+			socket.packet(&Packet{
+				Type:      PacketEvent,
+				Namespace: nsp.name,
+				Data:      data,
+			})
+		}
+	}
+
+	if f := nsp.connectionHandler(); f != nil {
+		socket.guard(EventConnection, func() {
+			f(socket)
+		})
+	}
+}
+
+func (nsp *Namespace) broadcast() *Broadcast {
+	return &Broadcast{
+		nsp:        nsp,
+		includeAll: true,
+		excludes:   make(map[string]struct{}),
+	}
+}
+
+// Emit sends an event to every socket of the namespace.
+func (nsp *Namespace) Emit(eName string, args ...interface{}) {
+	nsp.broadcast().Emit(eName, args...)
+}
+
+func (nsp *Namespace) To(rooms ...string) *Broadcast {
+	return nsp.broadcast().To(rooms...)
+}
+
+// In is an alias of To.
+func (nsp *Namespace) In(rooms ...string) *Broadcast {
+	return nsp.To(rooms...)
+}
+
+func (nsp *Namespace) Except(rooms ...string) *Broadcast {
+	return nsp.broadcast().Except(rooms...)
+}
+
+func (nsp *Namespace) FetchSockets() ([]*RemoteSocket, error) {
+	return nsp.broadcast().FetchSockets()
+}
+
+func (nsp *Namespace) SocketsJoin(rooms ...string) {
+	nsp.broadcast().SocketsJoin(rooms...)
+}
+
+func (nsp *Namespace) SocketsLeave(rooms ...string) {
+	nsp.broadcast().SocketsLeave(rooms...)
+}
+
+func (nsp *Namespace) DisconnectSockets(closeConn bool) {
+	nsp.broadcast().DisconnectSockets(closeConn)
+}
+
+func (nsp *Namespace) Logger() *zap.SugaredLogger {
+	return nsp.logger
+}
+
+func (nsp *Namespace) Adapter() Adapter {
+	return nsp.adapter
+}
+
+// Rooms returns every room with at least one socket on any server, including
+// the private room each socket has under its own id. With a distributed
+// adapter, the rooms collected before an error are returned along with it.
+func (nsp *Namespace) Rooms() ([]string, error) {
+	rooms, err := nsp.adapter.AllRooms()
+	return sortedKeys(rooms), err
+}
+
+// nextAckId allocates the acknowledgement id of a broadcast, unique within
+// the namespace. Broadcast ids are odd, the ids a socket allocates for its
+// own emits even, so that they never collide in the socket's pending acks.
+func (nsp *Namespace) nextAckId() int {
+	return int(nsp.ids.Add(1)-1)*2 + 1
 }
 
 func (nsp *Namespace) Remove(sid string) {
@@ -0,0 +1,103 @@
+package socketigo
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareErrors(t *testing.T) {
+	srv, url := newTestServer(t)
+	srv.Of("/connect-error").Use(func(socket *Socket, next func(error)) {
+		next(&ConnectError{Message: "not authorized", Data: map[string]interface{}{"code": 401}})
+	})
+	srv.Of("/error").Use(func(socket *Socket, next func(error)) {
+		next(errors.New("rejected"))
+	})
+
+	var lock sync.Mutex
+	var reached []string
+	for _, name := range []string{"/connect-error", "/error"} {
+		nsp := srv.Of(name)
+		nsp.Use(func(socket *Socket, next func(error)) {
+			lock.Lock()
+			reached = append(reached, "middleware")
+			lock.Unlock()
+			next(nil)
+		})
+		nsp.OnConnection(func(socket *Socket) {
+			lock.Lock()
+			reached = append(reached, "connection")
+			lock.Unlock()
+		})
+	}
+
+	tests := []struct {
+		nsp  string
+		want string
+	}{
+		{"/connect-error", `4/connect-error,{"message":"not authorized","data":{"code":401}}`},
+		{"/error", `4/error,{"message":"rejected"}`},
+	}
+	for _, tt := range tests {
+		c := dial(t, url)
+		if reply, err := c.Connect(tt.nsp); err != nil || reply != tt.want {
+			t.Errorf("got %q, %v, want %s", reply, err, tt.want)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(reached) != 0 {
+		t.Errorf("rejected sockets reached %v", reached)
+	}
+}
+
+// The middlewares of a parent namespace run before those of its children, in
+// the order they were added, even when they call next asynchronously.
+func TestMiddlewareOrder(t *testing.T) {
+	srv, url := newTestServer(t)
+	pnsp := srv.OfRegexp(regexp.MustCompile(`^/dynamic-\d+$`))
+	var lock sync.Mutex
+	var order []string
+	use := func(use func(MiddlewareFunction), name string, async bool) {
+		use(func(socket *Socket, next func(error)) {
+			lock.Lock()
+			order = append(order, name)
+			lock.Unlock()
+			if async {
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					next(nil)
+				}()
+				return
+			}
+			next(nil)
+		})
+	}
+	use(pnsp.Use, "parent 1", false)
+	use(pnsp.Use, "parent 2", true)
+
+	connected := make(chan struct{})
+	pnsp.OnConnection(func(socket *Socket) {
+		close(connected)
+	})
+	nsp, ok := srv.namespace("/dynamic-1", nil)
+	if !ok {
+		t.Fatal("namespace not created")
+	}
+	use(nsp.Use, "child 1", true)
+	use(nsp.Use, "child 2", false)
+
+	connect(t, url, "/dynamic-1")
+	<-connected
+
+	lock.Lock()
+	defer lock.Unlock()
+	if got := strings.Join(order, ","); got != "parent 1,parent 2,child 1,child 2" {
+		t.Errorf("got order %s", got)
+	}
+}
@@ -12,6 +12,10 @@ type Packet struct {
 	DataKind         reflect.Kind
 	Id               *int
 	NumOfAttachments int
+
+	// raw is the payload as received on the wire, when the parser keeps it
+	// to decode arguments straight into handler types.
+	raw []byte
 }
 
 type PacketType int
@@ -47,4 +51,6 @@ const (
 
 	DRTransportClose DisconnectReason = "transport close"
 	DRTransportError DisconnectReason = "transport error"
+
+	DRServerShuttingDown DisconnectReason = "server shutting down"
 )
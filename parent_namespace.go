@@ -0,0 +1,112 @@
+package socketigo
+
+import (
+	"regexp"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// NamespaceMatcher decides whether a namespace that was not registered with
+// Server.Of may be created on the fly for the given CONNECT auth payload.
+type NamespaceMatcher func(name string, auth map[string]interface{}) bool
+
+// ParentNamespace lazily creates child namespaces matching its matcher.
+// Children share the parent's connection handler and middlewares.
+type ParentNamespace struct {
+	server  *Server
+	matcher NamespaceMatcher
+
+	onConnection SocketFunction
+
+	sync.RWMutex
+	children map[string]*Namespace
+	fns      []MiddlewareFunction
+
+	logger *zap.SugaredLogger
+}
+
+func newParentNamespace(s *Server, matcher NamespaceMatcher) *ParentNamespace {
+	return &ParentNamespace{
+		server:   s,
+		matcher:  matcher,
+		children: make(map[string]*Namespace),
+		logger:   s.logger.With("ParentNamespace", len(s.parentNsps)),
+	}
+}
+
+func (s *Server) OfMatcher(matcher NamespaceMatcher) *ParentNamespace {
+	s.nspLock.Lock()
+	defer s.nspLock.Unlock()
+
+	pnsp := newParentNamespace(s, matcher)
+	s.parentNsps = append(s.parentNsps, pnsp)
+
+	return pnsp
+}
+
+func (s *Server) OfRegexp(re *regexp.Regexp) *ParentNamespace {
+	return s.OfMatcher(func(name string, _ map[string]interface{}) bool {
+		return re.MatchString(name)
+	})
+}
+
+func (pnsp *ParentNamespace) OnConnection(f SocketFunction) {
+	pnsp.Lock()
+	defer pnsp.Unlock()
+	pnsp.onConnection = f
+}
+
+func (pnsp *ParentNamespace) connectionHandler() SocketFunction {
+	pnsp.RLock()
+	defer pnsp.RUnlock()
+	return pnsp.onConnection
+}
+
+func (pnsp *ParentNamespace) Use(f MiddlewareFunction) {
+	pnsp.Lock()
+	defer pnsp.Unlock()
+	pnsp.fns = append(pnsp.fns, f)
+}
+
+func (pnsp *ParentNamespace) middlewares() []MiddlewareFunction {
+	pnsp.RLock()
+	defer pnsp.RUnlock()
+	return append([]MiddlewareFunction(nil), pnsp.fns...)
+}
+
+// Children returns the namespaces created by this parent so far.
+func (pnsp *ParentNamespace) Children() []*Namespace {
+	pnsp.RLock()
+	defer pnsp.RUnlock()
+
+	children := make([]*Namespace, 0, len(pnsp.children))
+	for _, nsp := range pnsp.children {
+		children = append(children, nsp)
+	}
+	return children
+}
+
+// Emit broadcasts the event to every socket of every child namespace.
+func (pnsp *ParentNamespace) Emit(eName string, args ...interface{}) {
+	for _, nsp := range pnsp.Children() {
+		nsp.Emit(eName, args...)
+	}
+}
+
+func (pnsp *ParentNamespace) createChild(name string) *Namespace {
+	pnsp.Lock()
+	defer pnsp.Unlock()
+
+	if nsp, ok := pnsp.children[name]; ok {
+		return nsp
+	}
+
+	nsp := NewNamespace(pnsp.server, name)
+	nsp.parent = pnsp
+	pnsp.children[name] = nsp
+
+	pnsp.logger.Debugf("Created child namespace %s", name)
+
+	return nsp
+}
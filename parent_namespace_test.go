@@ -0,0 +1,104 @@
+package socketigo
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOfRegexp(t *testing.T) {
+	srv, url := newTestServer(t)
+	pnsp := srv.OfRegexp(regexp.MustCompile(`^/dynamic-\d+$`))
+	names := make(chan string, 1)
+	pnsp.OnConnection(func(socket *Socket) {
+		names <- socket.nsp.Name()
+	})
+
+	connect(t, url, "/dynamic-1")
+	if name := <-names; name != "/dynamic-1" {
+		t.Errorf("connected to %s", name)
+	}
+	children := pnsp.Children()
+	if len(children) != 1 || children[0].Name() != "/dynamic-1" || srv.Of("/dynamic-1") != children[0] {
+		t.Errorf("got children %v", children)
+	}
+
+	c := dial(t, url)
+	if reply, err := c.Connect("/dynamic-a"); err != nil || reply != `4/dynamic-a,{"message":"Invalid namespace"}` {
+		t.Errorf("got %q, %v", reply, err)
+	}
+	if n := len(pnsp.Children()); n != 1 {
+		t.Errorf("got %d children", n)
+	}
+}
+
+func TestOfMatcherAuth(t *testing.T) {
+	srv, url := newTestServer(t)
+	pnsp := srv.OfMatcher(func(name string, auth map[string]interface{}) bool {
+		return strings.HasPrefix(name, "/team-") && auth["token"] == "secret"
+	})
+	pnsp.OnConnection(func(*Socket) {})
+
+	tests := []struct {
+		packet string
+		want   string
+	}{
+		{`0/team-a,{"token":"secret"}`, "0/team-a,{"},
+		{`0/team-b,{"token":"wrong"}`, `4/team-b,{"message":"Invalid namespace"}`},
+		{`0/team-c,`, `4/team-c,{"message":"Invalid namespace"}`},
+	}
+	for _, tt := range tests {
+		c := dial(t, url)
+		if err := c.Send(tt.packet); err != nil {
+			t.Fatal(err)
+		}
+		if reply, err := c.Expect(time.Second); err != nil || !strings.HasPrefix(reply, tt.want) {
+			t.Errorf("%s: got %q, %v, want %s", tt.packet, reply, err, tt.want)
+		}
+	}
+
+	children := pnsp.Children()
+	if len(children) != 1 || children[0].Name() != "/team-a" {
+		t.Errorf("got children %v", children)
+	}
+}
+
+// Clients connecting at the same time to a new namespace share one child.
+func TestOfMatcherConcurrentConnects(t *testing.T) {
+	const n = 10
+
+	srv, url := newTestServer(t)
+	pnsp := srv.OfRegexp(regexp.MustCompile(`^/dynamic-\d+$`))
+	namespaces := make(chan *Namespace, n)
+	pnsp.OnConnection(func(socket *Socket) {
+		namespaces <- socket.nsp
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		c := dial(t, url)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reply, err := c.Connect("/dynamic-7"); err != nil || !strings.HasPrefix(reply, "0/dynamic-7,{") {
+				t.Errorf("got %q, %v", reply, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	children := pnsp.Children()
+	if len(children) != 1 {
+		t.Fatalf("got %d children", len(children))
+	}
+	for i := 0; i < n; i++ {
+		if nsp := <-namespaces; nsp != children[0] {
+			t.Errorf("socket connected to another namespace %p", nsp)
+		}
+	}
+	if sockets := children[0].adapter.Sockets(nil); len(sockets) != n {
+		t.Errorf("got %d sockets", len(sockets))
+	}
+}
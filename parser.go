@@ -5,26 +5,60 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"strconv"
 
 	"github.com/taogames/engine.igo/message"
 )
 
+// Parser encodes packets and parses event payloads. It is shared by every
+// connection of a server, so any decoding state lives in the Decoder it
+// creates for each connection.
 type Parser interface {
-	Decode(*message.Message) (*Packet, error)
 	Encode(*Packet) ([]*message.Message, error)
+	NewDecoder() Decoder
 
 	ParseEventName(*Packet) (string, error)
 	ParseEventArgs(*Packet, []reflect.Type, bool) ([]reflect.Value, error)
+	ParseAckArgs(*Packet, []reflect.Type, bool) ([]reflect.Value, error)
+}
+
+// Decoder turns engine messages into packets. A nil packet without error
+// means the message was buffered, e.g. a binary attachment.
+type Decoder interface {
+	Decode(*message.Message) (*Packet, error)
 }
 
-var DefaultParser *defaultParser = &defaultParser{
-	recon: &reconstructor{},
+// payloadDecoder is implemented by the parsers that leave the payload of
+// events undecoded until it is needed.
+type payloadDecoder interface {
+	decodePayload([]byte) (interface{}, error)
 }
 
-type defaultParser struct {
-	recon *reconstructor
+// eventData returns the payload of the event as a generic value.
+func eventData(p Parser, packet *Packet) (interface{}, error) {
+	if packet.Data != nil || packet.raw == nil {
+		return packet.Data, nil
+	}
+	pd, ok := p.(payloadDecoder)
+	if !ok {
+		return nil, nil
+	}
+	return pd.decodePayload(packet.raw)
+}
+
+var DefaultParser *defaultParser = &defaultParser{}
+
+type defaultParser struct{}
+
+type defaultDecoder struct {
+	parser *defaultParser
+	recon  reconstructor
+}
+
+func (p *defaultParser) NewDecoder() Decoder {
+	return &defaultDecoder{parser: p}
 }
 
 type reconstructor struct {
@@ -37,37 +71,35 @@ func (recon *reconstructor) reset(packet *Packet) {
 	recon.buffers = nil
 }
 
-func (recon *reconstructor) takeBinary(data []byte) (bool, *Packet) {
+func (recon *reconstructor) takeBinary(data []byte) (*Packet, error) {
 	recon.buffers = append(recon.buffers, data)
 	if len(recon.buffers) == recon.packet.NumOfAttachments {
-		return true, recon.build()
+		packet, err := recon.build()
+		recon.reset(nil)
+		return packet, err
 	}
-	return false, nil
+	return nil, nil
 }
 
-func (recon *reconstructor) build() *Packet {
-	data := recon.packet.Data.([]interface{})
-
-	var bufIdx int
-	for placeIdx := range data {
-		if bufIdx >= len(recon.buffers) {
-			break
-		}
-
-		m, ok := data[placeIdx].(map[string]interface{})
-		if ok && m["_placeholder"] != nil {
-			data[placeIdx] = recon.buffers[bufIdx]
-			bufIdx++
-		}
+func (recon *reconstructor) build() (*Packet, error) {
+	data, err := reconstruct(recon.packet.Data, recon.buffers)
+	if err != nil {
+		return nil, err
 	}
+	recon.packet.Data = data
+	// The raw payload still holds the placeholders.
+	recon.packet.raw = nil
 
-	return recon.packet
+	return recon.packet, nil
 }
 
-func (p *defaultParser) Decode(msg *message.Message) (*Packet, error) {
+func (d *defaultDecoder) Decode(msg *message.Message) (*Packet, error) {
 	switch msg.Type {
 	case message.MTText:
-		packet, err := p.decodeString(msg.Data)
+		if d.recon.packet != nil {
+			return nil, errors.New("got plaintext data when reconstructing a packet")
+		}
+		packet, err := d.parser.decodeString(msg.Data)
 		if err != nil {
 			return nil, err
 		}
@@ -76,7 +108,7 @@ func (p *defaultParser) Decode(msg *message.Message) (*Packet, error) {
 			if packet.NumOfAttachments == 0 {
 				return packet, nil
 			} else {
-				p.recon.reset(packet)
+				d.recon.reset(packet)
 				return nil, nil
 			}
 		default:
@@ -84,11 +116,10 @@ func (p *defaultParser) Decode(msg *message.Message) (*Packet, error) {
 		}
 
 	case message.MTBinary:
-		isFull, packet := p.recon.takeBinary(msg.Data)
-		if isFull {
-			return packet, nil
+		if d.recon.packet == nil {
+			return nil, errors.New("got binary data when not reconstructing a packet")
 		}
-		return nil, nil
+		return d.recon.takeBinary(msg.Data)
 
 	default:
 		return nil, errors.New("invalid message type")
@@ -113,8 +144,7 @@ func (p *defaultParser) decodeString(bs []byte) (*Packet, error) {
 	// Num of attchments
 	if pt == PacketBinaryEvent || pt == PacketBinaryAck {
 		begin := i
-		for {
-			i++
+		for ; ; i++ {
 			if i == len(bs) {
 				return nil, fmt.Errorf("empty binary packet %v", string(bs))
 			}
@@ -171,17 +201,31 @@ func (p *defaultParser) decodeString(bs []byte) (*Packet, error) {
 	// Data
 
 	if len(bs[i:]) > 0 {
-		var payload any
-		dec := json.NewDecoder(bytes.NewReader(bs[i:]))
-		dec.UseNumber()
-		if err := dec.Decode(&payload); err != nil {
-			return nil, err
+		raw := bs[i:]
+		packet.raw = raw
+
+		// The arguments of events are decoded straight into the handler
+		// types, so the payload is only checked here.
+		if pt == PacketEvent {
+			if !json.Valid(raw) {
+				return nil, fmt.Errorf("invalid packet payload %v", string(bs))
+			}
+			if _, err := p.rawEventName(raw); err != nil {
+				return nil, fmt.Errorf("invalid packet payload %v", string(bs))
+			}
+			packet.DataKind = reflect.Slice
+			return packet, nil
+		}
+
+		payload, err := p.decodePayload(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid packet payload %v: %w", string(bs), err)
 		}
 
 		packet.Data = payload
 		packet.DataKind = reflect.ValueOf(payload).Kind()
 
-		if !p.isPayloadValid(packet) {
+		if !isPayloadValid(packet) {
 			return nil, fmt.Errorf("invalid packet payload %v", string(bs))
 		}
 	}
@@ -189,7 +233,37 @@ func (p *defaultParser) decodeString(bs []byte) (*Packet, error) {
 	return packet, nil
 }
 
-func (p *defaultParser) isPayloadValid(packet *Packet) bool {
+func (p *defaultParser) decodePayload(raw []byte) (interface{}, error) {
+	var payload interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&payload); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("trailing data")
+	}
+	return payload, nil
+}
+
+// rawEventName reads the first element of the payload array.
+func (p *defaultParser) rawEventName(raw []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return "", fmt.Errorf("invalid event payload %s", raw)
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	name, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("invalid event payload %s", raw)
+	}
+	return name, nil
+}
+
+func isPayloadValid(packet *Packet) bool {
 	switch packet.Type {
 	case PacketConnect:
 		return packet.DataKind == reflect.Map
@@ -216,15 +290,6 @@ func isDigit(b byte) bool {
 	return b >= '0' && b <= '9'
 }
 
-func itob(i int) byte {
-	return '0' + byte(i)
-}
-
-type binaryPlaceholder struct {
-	Placeholder bool `json:"_placeholder"`
-	Num         int  `json:"num"`
-}
-
 func (p *defaultParser) Encode(packet *Packet) ([]*message.Message, error) {
 	msgs := make([]*message.Message, 1)
 
@@ -233,13 +298,15 @@ func (p *defaultParser) Encode(packet *Packet) ([]*message.Message, error) {
 	packet.DataKind = reflect.ValueOf(packet.Data).Kind()
 
 	// Type & Bin
-	if packet.Type == PacketEvent || packet.Type == PacketAck {
+	pt := packet.Type
+	payload := packet.Data
+	var attachments [][]byte
+	if pt == PacketEvent || pt == PacketAck {
 		data, ok := packet.Data.([]interface{})
 		if !ok {
 			return nil, fmt.Errorf("invalid event packet data type: %+v", packet)
 		}
-		argBegin := 0
-		if packet.Type == PacketEvent {
+		if pt == PacketEvent {
 			if len(data) == 0 {
 				return nil, fmt.Errorf("invalid event packet data length: %+v", packet)
 			}
@@ -247,28 +314,27 @@ func (p *defaultParser) Encode(packet *Packet) ([]*message.Message, error) {
 			if !ok {
 				return nil, fmt.Errorf("invalid event packet data name: %+v", packet)
 			}
-			argBegin = 1
 		}
 
-		for i := argBegin; i < len(data); i++ {
-			bs, ok := data[i].([]byte)
-			if ok {
-				data[i] = &binaryPlaceholder{Placeholder: true, Num: packet.NumOfAttachments}
-				packet.NumOfAttachments++
+		var err error
+		if payload, attachments, err = deconstruct(data); err != nil {
+			return nil, err
+		}
+		if len(attachments) > 0 {
+			for _, bs := range attachments {
 				msgs = append(msgs, &message.Message{Type: message.MTBinary, Data: bs})
 			}
-		}
-		if packet.NumOfAttachments > 0 {
-			if packet.Type == PacketEvent {
-				packet.Type = PacketBinaryEvent
+			if pt == PacketEvent {
+				pt = PacketBinaryEvent
 			} else {
-				packet.Type = PacketBinaryAck
+				pt = PacketBinaryAck
 			}
 		}
 	}
-	buffer.WriteByte(itob(int(packet.Type)))
-	if packet.Type == PacketBinaryEvent || packet.Type == PacketBinaryAck {
-		buffer.Write([]byte{itob(packet.NumOfAttachments), '-'})
+	buffer.WriteByte(pt.Byte())
+	if pt == PacketBinaryEvent || pt == PacketBinaryAck {
+		buffer.WriteString(strconv.Itoa(len(attachments)))
+		buffer.WriteByte('-')
 	}
 
 	// Nsp
@@ -279,15 +345,17 @@ func (p *defaultParser) Encode(packet *Packet) ([]*message.Message, error) {
 
 	// Ack
 	if packet.Id != nil {
-		buffer.WriteByte(itob(*packet.Id))
+		buffer.WriteString(strconv.Itoa(*packet.Id))
 	}
 
 	// Data
-	bs, err := json.Marshal(packet.Data)
-	if err != nil {
-		return nil, err
+	if payload != nil {
+		bs, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(bs)
 	}
-	buffer.Write(bs)
 
 	// Build
 	msgs[0] = &message.Message{Type: message.MTText, Data: buffer.Bytes()}
@@ -296,6 +364,13 @@ func (p *defaultParser) Encode(packet *Packet) ([]*message.Message, error) {
 }
 
 func (p *defaultParser) ParseEventName(packet *Packet) (string, error) {
+	if packet.Data == nil && packet.raw != nil {
+		return p.rawEventName(packet.raw)
+	}
+	return parseEventName(packet)
+}
+
+func parseEventName(packet *Packet) (string, error) {
 	if packet.DataKind == reflect.Slice && reflect.ValueOf(packet.Data).Len() > 0 {
 		name, ok := packet.Data.([]interface{})[0].(string)
 		if ok {
@@ -306,9 +381,58 @@ func (p *defaultParser) ParseEventName(packet *Packet) (string, error) {
 }
 
 func (p *defaultParser) ParseEventArgs(packet *Packet, types []reflect.Type, isVariadic bool) ([]reflect.Value, error) {
-	data, _ := json.Marshal(packet.Data.([]interface{})[1:])
-	dec := json.NewDecoder(bytes.NewReader(data))
-	_, err := dec.Token()
+	return p.parseArgs(packet, 1, types, isVariadic)
+}
+
+func (p *defaultParser) ParseAckArgs(packet *Packet, types []reflect.Type, isVariadic bool) ([]reflect.Value, error) {
+	if _, ok := packet.Data.([]interface{}); !ok {
+		return nil, fmt.Errorf("invalid ack packet: %+v", packet)
+	}
+	return p.parseArgs(packet, 0, types, isVariadic)
+}
+
+func (p *defaultParser) DecodeEventArgs(packet *Packet, dst ...interface{}) error {
+	dec, err := p.argsDecoder(packet, 1)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(dst) && dec.More(); i++ {
+		if err := dec.Decode(dst[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// argsDecoder returns a decoder positioned on the payload array element at
+// index skip, reading the payload as received when possible.
+func (p *defaultParser) argsDecoder(packet *Packet, skip int) (*json.Decoder, error) {
+	raw := packet.raw
+	if raw == nil {
+		var err error
+		if raw, err = json.Marshal(packet.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	for i := 0; i < skip; i++ {
+		if !dec.More() {
+			return nil, fmt.Errorf("invalid packet: %+v", packet)
+		}
+		var skipped json.RawMessage
+		if err := dec.Decode(&skipped); err != nil {
+			return nil, err
+		}
+	}
+	return dec, nil
+}
+
+func (p *defaultParser) parseArgs(packet *Packet, skip int, types []reflect.Type, isVariadic bool) ([]reflect.Value, error) {
+	dec, err := p.argsDecoder(packet, skip)
 	if err != nil {
 		return nil, err
 	}
@@ -335,10 +459,10 @@ func (p *defaultParser) ParseEventArgs(packet *Packet, types []reflect.Type, isV
 		}
 	}
 
-	args := make([]reflect.Value, len(pointers))
+	values := make([]reflect.Value, len(pointers))
 	for i := range pointers {
-		args[i] = pointers[i].Elem()
+		values[i] = pointers[i].Elem()
 	}
 
-	return args, nil
+	return values, nil
 }
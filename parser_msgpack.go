@@ -0,0 +1,240 @@
+package socketigo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/taogames/engine.igo/message"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgpackParser is wire-compatible with socket.io-msgpack-parser: every packet
+// is encoded as a single binary message, binary data included.
+var MsgpackParser *msgpackParser = &msgpackParser{}
+
+type msgpackParser struct{}
+
+type msgpackDecoder struct {
+	parser *msgpackParser
+}
+
+type msgpackPacket struct {
+	Type PacketType         `msgpack:"type"`
+	Nsp  string             `msgpack:"nsp"`
+	Data msgpack.RawMessage `msgpack:"data,omitempty"`
+	Id   *int               `msgpack:"id,omitempty"`
+}
+
+type msgpackOutPacket struct {
+	Type PacketType  `msgpack:"type"`
+	Nsp  string      `msgpack:"nsp"`
+	Data interface{} `msgpack:"data,omitempty"`
+	Id   *int        `msgpack:"id,omitempty"`
+}
+
+const msgpackStructTag = "json"
+
+func (p *msgpackParser) NewDecoder() Decoder {
+	return &msgpackDecoder{parser: p}
+}
+
+func (d *msgpackDecoder) Decode(msg *message.Message) (*Packet, error) {
+	if msg.Type != message.MTBinary {
+		return nil, errors.New("msgpack parser expects binary messages")
+	}
+
+	var mp msgpackPacket
+	if err := msgpack.Unmarshal(msg.Data, &mp); err != nil {
+		return nil, err
+	}
+	if mp.Type < PacketConnect || mp.Type > PacketBinaryAck {
+		return nil, fmt.Errorf("socket packet type invalid: %d", mp.Type)
+	}
+
+	packet := &Packet{
+		Type:      mp.Type,
+		Namespace: mp.Nsp,
+		Id:        mp.Id,
+	}
+	if packet.Namespace == "" {
+		packet.Namespace = MainNamespace
+	}
+
+	if len(mp.Data) > 0 {
+		// As with the default parser, the payload of events is only checked.
+		if packet.Type == PacketEvent {
+			if err := d.parser.checkEvent(mp.Data); err != nil {
+				return nil, fmt.Errorf("invalid packet payload: %w", err)
+			}
+			packet.DataKind = reflect.Slice
+			packet.raw = mp.Data
+			return packet, nil
+		}
+
+		payload, err := d.parser.decodePayload(mp.Data)
+		if err != nil {
+			return nil, err
+		}
+		if payload == nil {
+			return packet, nil
+		}
+
+		packet.Data = payload
+		packet.DataKind = reflect.ValueOf(payload).Kind()
+		packet.raw = mp.Data
+
+		if !isPayloadValid(packet) {
+			return nil, fmt.Errorf("invalid packet payload %v", payload)
+		}
+	}
+
+	return packet, nil
+}
+
+func (p *msgpackParser) Encode(packet *Packet) ([]*message.Message, error) {
+	var buffer bytes.Buffer
+
+	enc := msgpack.NewEncoder(&buffer)
+	enc.SetCustomStructTag(msgpackStructTag)
+	enc.UseCompactInts(true)
+
+	err := enc.Encode(&msgpackOutPacket{
+		Type: packet.Type,
+		Nsp:  packet.Namespace,
+		Data: packet.Data,
+		Id:   packet.Id,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []*message.Message{{Type: message.MTBinary, Data: buffer.Bytes()}}, nil
+}
+
+func (p *msgpackParser) ParseEventName(packet *Packet) (string, error) {
+	if packet.Data == nil && packet.raw != nil {
+		name, _, _, err := p.rawEventName(packet.raw)
+		return name, err
+	}
+	return parseEventName(packet)
+}
+
+func (p *msgpackParser) decodePayload(raw []byte) (interface{}, error) {
+	return p.newDecoder(raw).DecodeInterface()
+}
+
+// rawEventName reads the first element of the payload array, returning the
+// decoder positioned on the arguments along with their number.
+func (p *msgpackParser) rawEventName(raw []byte) (string, *msgpack.Decoder, int, error) {
+	dec := p.newDecoder(raw)
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return "", nil, 0, err
+	}
+	if n < 1 {
+		return "", nil, 0, errors.New("event without name")
+	}
+	name, err := dec.DecodeString()
+	return name, dec, n - 1, err
+}
+
+// checkEvent checks that the payload is an array starting with a string,
+// skipping through the arguments.
+func (p *msgpackParser) checkEvent(raw []byte) error {
+	_, dec, n, err := p.rawEventName(raw)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := dec.Skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *msgpackParser) ParseEventArgs(packet *Packet, types []reflect.Type, isVariadic bool) ([]reflect.Value, error) {
+	return p.parseArgs(packet, 1, types, isVariadic)
+}
+
+func (p *msgpackParser) ParseAckArgs(packet *Packet, types []reflect.Type, isVariadic bool) ([]reflect.Value, error) {
+	return p.parseArgs(packet, 0, types, isVariadic)
+}
+
+func (p *msgpackParser) newDecoder(data []byte) *msgpack.Decoder {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag(msgpackStructTag)
+	return dec
+}
+
+func (p *msgpackParser) DecodeEventArgs(packet *Packet, dst ...interface{}) error {
+	dec, n, err := p.argsDecoder(packet, 1)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(dst) && i < n; i++ {
+		if err := dec.Decode(dst[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// argsDecoder returns a decoder positioned on the payload array element at
+// index skip, along with the number of elements left.
+func (p *msgpackParser) argsDecoder(packet *Packet, skip int) (*msgpack.Decoder, int, error) {
+	raw := packet.raw
+	if raw == nil {
+		var err error
+		if raw, err = msgpack.Marshal(packet.Data); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	dec := p.newDecoder(raw)
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return nil, 0, err
+	}
+	if n < skip {
+		return nil, 0, fmt.Errorf("invalid packet: %+v", packet)
+	}
+	for i := 0; i < skip; i++ {
+		if err := dec.Skip(); err != nil {
+			return nil, 0, err
+		}
+	}
+	return dec, n - skip, nil
+}
+
+// parseArgs decodes the payload array, skipping its first elements, straight
+// into the given types.
+func (p *msgpackParser) parseArgs(packet *Packet, skip int, types []reflect.Type, isVariadic bool) ([]reflect.Value, error) {
+	dec, n, err := p.argsDecoder(packet, skip)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]reflect.Value, n)
+	for i := range values {
+		var t reflect.Type
+		if isVariadic && i >= len(types)-1 {
+			t = types[len(types)-1].Elem()
+		} else {
+			if i >= len(types) {
+				return nil, fmt.Errorf("invalid event args")
+			}
+			t = types[i]
+		}
+
+		v := reflect.New(t).Elem()
+		if err := dec.DecodeValue(v); err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	return values, nil
+}
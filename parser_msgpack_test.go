@@ -0,0 +1,287 @@
+package socketigo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/taogames/engine.igo/message"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// The fixtures are the bytes socket.io-msgpack-parser (notepack.io) produces
+// for the packets the reference client and server build, keys in the order
+// they set them. Clients also send the emit options, which must be ignored.
+
+func fixture(t *testing.T, s string) []byte {
+	t.Helper()
+	bs, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bs
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestMsgpackDecodeFixtures(t *testing.T) {
+	tests := []struct {
+		name  string
+		bytes string
+		want  *Packet
+	}{
+		{
+			// {type: 0, nsp: "/"}
+			name:  "connect",
+			bytes: "82 a474797065 00 a36e7370 a12f",
+			want:  &Packet{Type: PacketConnect, Namespace: "/"},
+		},
+		{
+			// {type: 0, data: {token: "abc"}, nsp: "/admin"}
+			name:  "connect with auth",
+			bytes: "83 a474797065 00 a464617461 81 a5746f6b656e a3616263 a36e7370 a62f61646d696e",
+			want: &Packet{
+				Type:      PacketConnect,
+				Namespace: "/admin",
+				Data:      map[string]interface{}{"token": "abc"},
+				DataKind:  reflect.Map,
+			},
+		},
+		{
+			// {type: 1, nsp: "/admin"}
+			name:  "disconnect",
+			bytes: "82 a474797065 01 a36e7370 a62f61646d696e",
+			want:  &Packet{Type: PacketDisconnect, Namespace: "/admin"},
+		},
+		{
+			// {type: 2, data: ["hello", 1, "a"], options: {compress: true}, nsp: "/"}
+			name:  "event",
+			bytes: "84 a474797065 02 a464617461 93 a568656c6c6f 01 a161 a76f7074696f6e73 81 a8636f6d7072657373 c3 a36e7370 a12f",
+			want: &Packet{
+				Type:      PacketEvent,
+				Namespace: "/",
+				Data:      []interface{}{"hello", int8(1), "a"},
+				DataKind:  reflect.Slice,
+			},
+		},
+		{
+			// {type: 2, data: ["hello"], options: {compress: true}, id: 12, nsp: "/"}
+			name:  "event with ack",
+			bytes: "85 a474797065 02 a464617461 91 a568656c6c6f a76f7074696f6e73 81 a8636f6d7072657373 c3 a26964 0c a36e7370 a12f",
+			want: &Packet{
+				Type:      PacketEvent,
+				Namespace: "/",
+				Data:      []interface{}{"hello"},
+				DataKind:  reflect.Slice,
+				Id:        intPtr(12),
+			},
+		},
+		{
+			// {type: 2, data: ["upload", <Buffer 01 02 03>], options: {compress: true}, nsp: "/"}
+			name:  "binary event",
+			bytes: "84 a474797065 02 a464617461 92 a675706c6f6164 c403010203 a76f7074696f6e73 81 a8636f6d7072657373 c3 a36e7370 a12f",
+			want: &Packet{
+				Type:      PacketEvent,
+				Namespace: "/",
+				Data:      []interface{}{"upload", []byte{1, 2, 3}},
+				DataKind:  reflect.Slice,
+			},
+		},
+		{
+			// {type: 3, id: 5, data: ["ok"], nsp: "/"}
+			name:  "ack",
+			bytes: "84 a474797065 03 a26964 05 a464617461 91 a26f6b a36e7370 a12f",
+			want: &Packet{
+				Type:      PacketAck,
+				Namespace: "/",
+				Data:      []interface{}{"ok"},
+				DataKind:  reflect.Slice,
+				Id:        intPtr(5),
+			},
+		},
+		{
+			// {type: 3, id: 300, data: [<Buffer de ad>], nsp: "/"}
+			name:  "binary ack",
+			bytes: "84 a474797065 03 a26964 cd012c a464617461 91 c402dead a36e7370 a12f",
+			want: &Packet{
+				Type:      PacketAck,
+				Namespace: "/",
+				Data:      []interface{}{[]byte{0xde, 0xad}},
+				DataKind:  reflect.Slice,
+				Id:        intPtr(300),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet, err := MsgpackParser.NewDecoder().Decode(&message.Message{
+				Type: message.MTBinary,
+				Data: fixture(t, tt.bytes),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			materialize(t, MsgpackParser, packet)
+			if !reflect.DeepEqual(packet, tt.want) {
+				t.Errorf("got %+v, want %+v", packet, tt.want)
+			}
+		})
+	}
+}
+
+func TestMsgpackDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		bytes string
+	}{
+		// {type: 7, nsp: "/"}
+		{"unknown type", "82 a474797065 07 a36e7370 a12f"},
+		// {type: 2, data: [1], nsp: "/"}
+		{"event without name", "83 a474797065 02 a464617461 91 01 a36e7370 a12f"},
+		// {type: 0, data: "abc", nsp: "/"}
+		{"connect with string", "83 a474797065 00 a464617461 a3616263 a36e7370 a12f"},
+		{"truncated", "82 a474797065"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := MsgpackParser.NewDecoder().Decode(&message.Message{
+				Type: message.MTBinary,
+				Data: fixture(t, tt.bytes),
+			})
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	_, err := MsgpackParser.NewDecoder().Decode(&message.Message{Type: message.MTText, Data: []byte("2[]")})
+	if err == nil {
+		t.Error("expected an error for a text message")
+	}
+}
+
+// The reference server orders the keys differently, so the encoded packets
+// are compared once decoded.
+func TestMsgpackEncodeFixtures(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet *Packet
+		bytes  string
+	}{
+		{
+			// {type: 0, data: {sid: "abc"}, nsp: "/"}
+			name: "connect",
+			packet: &Packet{
+				Type:      PacketConnect,
+				Namespace: "/",
+				Data:      map[string]interface{}{"sid": "abc"},
+			},
+			bytes: "83 a474797065 00 a464617461 81 a3736964 a3616263 a36e7370 a12f",
+		},
+		{
+			// {type: 1, nsp: "/admin"}
+			name:   "disconnect",
+			packet: &Packet{Type: PacketDisconnect, Namespace: "/admin"},
+			bytes:  "82 a474797065 01 a36e7370 a62f61646d696e",
+		},
+		{
+			// {type: 2, data: ["hello", "world"], nsp: "/"}
+			name: "event",
+			packet: &Packet{
+				Type:      PacketEvent,
+				Namespace: "/",
+				Data:      []interface{}{"hello", "world"},
+			},
+			bytes: "83 a474797065 02 a464617461 92 a568656c6c6f a5776f726c64 a36e7370 a12f",
+		},
+		{
+			// {type: 2, data: ["file", <Buffer 01 02 03>], id: 1, nsp: "/"}
+			name: "binary event with ack",
+			packet: &Packet{
+				Type:      PacketEvent,
+				Namespace: "/",
+				Data:      []interface{}{"file", []byte{1, 2, 3}},
+				Id:        intPtr(1),
+			},
+			bytes: "84 a474797065 02 a464617461 92 a466696c65 c403010203 a26964 01 a36e7370 a12f",
+		},
+		{
+			// {id: 7, type: 3, data: [null, {n: 2}], nsp: "/"}
+			name: "ack",
+			packet: &Packet{
+				Type:      PacketAck,
+				Namespace: "/",
+				Data:      []interface{}{nil, map[string]interface{}{"n": 2}},
+				Id:        intPtr(7),
+			},
+			bytes: "84 a26964 07 a474797065 03 a464617461 92 c0 81 a16e 02 a36e7370 a12f",
+		},
+		{
+			// {id: 300, type: 3, data: [<Buffer de ad>], nsp: "/"}
+			name: "binary ack",
+			packet: &Packet{
+				Type:      PacketAck,
+				Namespace: "/",
+				Data:      []interface{}{[]byte{0xde, 0xad}},
+				Id:        intPtr(300),
+			},
+			bytes: "84 a26964 cd012c a474797065 03 a464617461 91 c402dead a36e7370 a12f",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, err := MsgpackParser.Encode(tt.packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) != 1 || msgs[0].Type != message.MTBinary {
+				t.Fatalf("expected a single binary message, got %v", msgs)
+			}
+
+			var got, want interface{}
+			if err := msgpack.Unmarshal(msgs[0].Data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := msgpack.Unmarshal(fixture(t, tt.bytes), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMsgpackParseArgs(t *testing.T) {
+	type file struct {
+		Name string `json:"name"`
+		Body []byte `json:"body"`
+	}
+
+	// {type: 2, data: ["save", {name: "a.txt", body: <Buffer 68 69>}, 3], nsp: "/"}
+	packet, err := MsgpackParser.NewDecoder().Decode(&message.Message{
+		Type: message.MTBinary,
+		Data: fixture(t, "83 a474797065 02 a464617461 93 a473617665 82 a46e616d65 a5612e747874 a4626f6479 c4026869 03 a36e7370 a12f"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := MsgpackParser.ParseEventArgs(packet, []reflect.Type{reflect.TypeOf(file{}), reflect.TypeOf(0)}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := args[0].Interface().(file); got.Name != "a.txt" || !bytes.Equal(got.Body, []byte("hi")) {
+		t.Errorf("got %+v", got)
+	}
+	if got := args[1].Interface().(int); got != 3 {
+		t.Errorf("got %v, want 3", got)
+	}
+}
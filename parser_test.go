@@ -0,0 +1,334 @@
+package socketigo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/taogames/engine.igo/message"
+)
+
+// decodeAll decodes a text message followed by its attachments.
+func decodeAll(t *testing.T, text string, attachments ...[]byte) (*Packet, error) {
+	t.Helper()
+	dec := DefaultParser.NewDecoder()
+	packet, err := dec.Decode(&message.Message{Type: message.MTText, Data: []byte(text)})
+	for _, bs := range attachments {
+		if err != nil {
+			return nil, err
+		}
+		if packet != nil {
+			t.Fatalf("got a packet before its attachments: %+v", packet)
+		}
+		packet, err = dec.Decode(&message.Message{Type: message.MTBinary, Data: bs})
+	}
+	return packet, err
+}
+
+// normalize round-trips data through JSON, the way the decoder sees it.
+// materialize decodes the payload the decoder left raw so that the packet
+// can be compared with a literal.
+func materialize(t *testing.T, p Parser, packet *Packet) {
+	t.Helper()
+	data, err := eventData(p, packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet.Data = data
+	packet.raw = nil
+}
+
+func normalize(t *testing.T, data interface{}) interface{} {
+	t.Helper()
+	bs, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// The vectors of the socket.io protocol specification.
+func TestDecodeProtocolVectors(t *testing.T) {
+	tests := []struct {
+		text        string
+		attachments [][]byte
+		want        *Packet
+	}{
+		{"0", nil, &Packet{Type: PacketConnect, Namespace: "/"}},
+		{"0/admin,", nil, &Packet{Type: PacketConnect, Namespace: "/admin"}},
+		{"0/admin", nil, &Packet{Type: PacketConnect, Namespace: "/admin"}},
+		{`0{"token":"123"}`, nil, &Packet{
+			Type: PacketConnect, Namespace: "/", Data: map[string]interface{}{"token": "123"},
+		}},
+		{`0/admin,{"sid":"oSO0OpakMV_3jnilAAAA"}`, nil, &Packet{
+			Type: PacketConnect, Namespace: "/admin", Data: map[string]interface{}{"sid": "oSO0OpakMV_3jnilAAAA"},
+		}},
+		{"1/admin,", nil, &Packet{Type: PacketDisconnect, Namespace: "/admin"}},
+		{`2["foo"]`, nil, &Packet{Type: PacketEvent, Namespace: "/", Data: []interface{}{"foo"}}},
+		{`2/admin,["bar"]`, nil, &Packet{Type: PacketEvent, Namespace: "/admin", Data: []interface{}{"bar"}}},
+		{`212["foo"]`, nil, &Packet{Type: PacketEvent, Namespace: "/", Data: []interface{}{"foo"}, Id: intPtr(12)}},
+		{`2/admin,12345["foo",1]`, nil, &Packet{
+			Type: PacketEvent, Namespace: "/admin", Data: []interface{}{"foo", 1}, Id: intPtr(12345),
+		}},
+		{`3/admin,13["bar"]`, nil, &Packet{Type: PacketAck, Namespace: "/admin", Data: []interface{}{"bar"}, Id: intPtr(13)}},
+		{`30[]`, nil, &Packet{Type: PacketAck, Namespace: "/", Data: []interface{}{}, Id: intPtr(0)}},
+		{`4{"message":"Not authorized"}`, nil, &Packet{
+			Type: PacketConnectError, Namespace: "/", Data: map[string]interface{}{"message": "Not authorized"},
+		}},
+		{`4/admin,"Not authorized"`, nil, &Packet{Type: PacketConnectError, Namespace: "/admin", Data: "Not authorized"}},
+		{`51-["baz",{"_placeholder":true,"num":0}]`, [][]byte{{1, 2, 3, 4}}, &Packet{
+			Type: PacketBinaryEvent, Namespace: "/", Data: []interface{}{"baz", []byte{1, 2, 3, 4}}, NumOfAttachments: 1,
+		}},
+		{`51-/admin,["baz",{"_placeholder":true,"num":0}]`, [][]byte{{1}}, &Packet{
+			Type: PacketBinaryEvent, Namespace: "/admin", Data: []interface{}{"baz", []byte{1}}, NumOfAttachments: 1,
+		}},
+		{`51-12["baz",{"a":[{"_placeholder":true,"num":0}]}]`, [][]byte{{1}}, &Packet{
+			Type:             PacketBinaryEvent,
+			Namespace:        "/",
+			Data:             []interface{}{"baz", map[string]interface{}{"a": []interface{}{[]byte{1}}}},
+			Id:               intPtr(12),
+			NumOfAttachments: 1,
+		}},
+		{`61-/admin,15[{"_placeholder":true,"num":0}]`, [][]byte{{1}}, &Packet{
+			Type: PacketBinaryAck, Namespace: "/admin", Data: []interface{}{[]byte{1}}, Id: intPtr(15), NumOfAttachments: 1,
+		}},
+		// Binary packets without attachments are complete at once.
+		{`50-["baz",{"_placeholder":false}]`, nil, &Packet{
+			Type: PacketBinaryEvent, Namespace: "/", Data: []interface{}{"baz", map[string]interface{}{"_placeholder": false}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			packet, err := decodeAll(t, tt.text, tt.attachments...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if packet == nil {
+				t.Fatal("no packet decoded")
+			}
+
+			want := *tt.want
+			if want.Data != nil {
+				want.DataKind = reflect.ValueOf(want.Data).Kind()
+				if len(tt.attachments) == 0 {
+					want.Data = normalize(t, want.Data)
+				}
+			}
+			if len(tt.attachments) > 0 {
+				// The binary leaves do not go through JSON.
+				packet.Data = normalizeExceptBinary(t, packet.Data)
+				want.Data = normalizeExceptBinary(t, want.Data)
+			}
+			materialize(t, DefaultParser, packet)
+			if !reflect.DeepEqual(packet, &want) {
+				t.Errorf("got %+v, want %+v", packet, &want)
+			}
+		})
+	}
+}
+
+// normalizeExceptBinary converts the numbers of data to json.Number, leaving
+// []byte values untouched.
+func normalizeExceptBinary(t *testing.T, data interface{}) interface{} {
+	switch d := data.(type) {
+	case []byte:
+		return d
+	case []interface{}:
+		out := make([]interface{}, len(d))
+		for i := range d {
+			out[i] = normalizeExceptBinary(t, d[i])
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(d))
+		for k := range d {
+			out[k] = normalizeExceptBinary(t, d[k])
+		}
+		return out
+	default:
+		return normalize(t, d)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"9",
+		"a",
+		`0[]`,
+		`0"abc"`,
+		`1{}`,
+		`2[]`,
+		`2[1]`,
+		`2{"a":1}`,
+		`2["a"`,
+		`2["a"]x`,
+		`212`,
+		`3{}`,
+		`4[]`,
+		`5`,
+		`5-["a"]`,
+		`5a-["a"]`,
+		`51`,
+	}
+
+	for _, text := range tests {
+		t.Run(text, func(t *testing.T) {
+			packet, err := DefaultParser.NewDecoder().Decode(&message.Message{Type: message.MTText, Data: []byte(text)})
+			if err == nil {
+				t.Errorf("expected an error, got %+v", packet)
+			}
+		})
+	}
+}
+
+func TestDecodeUnexpectedMessages(t *testing.T) {
+	dec := DefaultParser.NewDecoder()
+	if _, err := dec.Decode(&message.Message{Type: message.MTBinary, Data: []byte{1}}); err == nil {
+		t.Error("expected an error for an attachment without packet")
+	}
+
+	if _, err := dec.Decode(&message.Message{Type: message.MTText, Data: []byte(`52-["a",{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.Decode(&message.Message{Type: message.MTText, Data: []byte(`2["a"]`)}); err == nil {
+		t.Error("expected an error for a text message while reconstructing")
+	}
+
+	dec = DefaultParser.NewDecoder()
+	if _, err := dec.Decode(&message.Message{Type: message.MTText, Data: []byte(`51-["a",{"_placeholder":true,"num":1}]`)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.Decode(&message.Message{Type: message.MTBinary, Data: []byte{1}}); err == nil {
+		t.Error("expected an error for an illegal attachment number")
+	}
+}
+
+func TestEncodeProtocolVectors(t *testing.T) {
+	tests := []struct {
+		packet      *Packet
+		text        string
+		attachments [][]byte
+	}{
+		{&Packet{Type: PacketConnect, Namespace: "/"}, "0", nil},
+		{&Packet{Type: PacketConnect, Namespace: "/admin", Data: map[string]interface{}{"sid": "oSO0OpakMV_3jnilAAAA"}},
+			`0/admin,{"sid":"oSO0OpakMV_3jnilAAAA"}`, nil},
+		{&Packet{Type: PacketDisconnect, Namespace: "/admin"}, "1/admin,", nil},
+		{&Packet{Type: PacketEvent, Namespace: "/", Data: []interface{}{"foo"}}, `2["foo"]`, nil},
+		{&Packet{Type: PacketEvent, Namespace: "/admin", Data: []interface{}{"bar"}}, `2/admin,["bar"]`, nil},
+		{&Packet{Type: PacketEvent, Namespace: "/", Data: []interface{}{"foo"}, Id: intPtr(12)}, `212["foo"]`, nil},
+		{&Packet{Type: PacketAck, Namespace: "/admin", Data: []interface{}{"bar"}, Id: intPtr(13)}, `3/admin,13["bar"]`, nil},
+		{&Packet{Type: PacketAck, Namespace: "/", Data: []interface{}{}, Id: intPtr(0)}, `30[]`, nil},
+		{&Packet{Type: PacketConnectError, Namespace: "/", Data: map[string]interface{}{"message": "Not authorized"}},
+			`4{"message":"Not authorized"}`, nil},
+		{&Packet{Type: PacketEvent, Namespace: "/", Data: []interface{}{"baz", []byte{1, 2, 3, 4}}},
+			`51-["baz",{"_placeholder":true,"num":0}]`, [][]byte{{1, 2, 3, 4}}},
+		{&Packet{Type: PacketEvent, Namespace: "/admin", Data: []interface{}{"baz", []byte{1}}, Id: intPtr(456)},
+			`51-/admin,456["baz",{"_placeholder":true,"num":0}]`, [][]byte{{1}}},
+		{&Packet{Type: PacketAck, Namespace: "/admin", Data: []interface{}{[]byte{1}}, Id: intPtr(15)},
+			`61-/admin,15[{"_placeholder":true,"num":0}]`, [][]byte{{1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			msgs, err := DefaultParser.Encode(tt.packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(msgs[0].Data); got != tt.text || msgs[0].Type != message.MTText {
+				t.Errorf("got %s, want %s", got, tt.text)
+			}
+			if len(msgs)-1 != len(tt.attachments) {
+				t.Fatalf("got %d attachments, want %d", len(msgs)-1, len(tt.attachments))
+			}
+			for i, bs := range tt.attachments {
+				if msgs[i+1].Type != message.MTBinary || !bytes.Equal(msgs[i+1].Data, bs) {
+					t.Errorf("attachment %d: got %v, want %v", i, msgs[i+1].Data, bs)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	tests := []*Packet{
+		{Type: PacketEvent, Namespace: "/"},
+		{Type: PacketEvent, Namespace: "/", Data: []interface{}{}},
+		{Type: PacketEvent, Namespace: "/", Data: []interface{}{1}},
+		{Type: PacketAck, Namespace: "/", Data: "a"},
+		{Type: PacketEvent, Namespace: "/", Data: []interface{}{"a", func() {}}},
+	}
+
+	for _, packet := range tests {
+		if msgs, err := DefaultParser.Encode(packet); err == nil {
+			t.Errorf("expected an error for %+v, got %s", packet, msgs[0].Data)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	many := make([]interface{}, 0, 12)
+	many = append(many, "many")
+	for i := 0; i < 11; i++ {
+		many = append(many, []byte{byte(i)})
+	}
+
+	tests := []*Packet{
+		{Type: PacketConnect, Namespace: "/"},
+		{Type: PacketConnect, Namespace: "/admin", Data: map[string]interface{}{"token": "123"}},
+		{Type: PacketDisconnect, Namespace: "/admin"},
+		{Type: PacketEvent, Namespace: "/", Data: []interface{}{"a", nil, "", 0, false, []interface{}{}, map[string]interface{}{}}},
+		{Type: PacketEvent, Namespace: "/", Data: []interface{}{"a"}, Id: intPtr(0)},
+		{Type: PacketEvent, Namespace: "/", Data: []interface{}{"a"}, Id: intPtr(9)},
+		{Type: PacketEvent, Namespace: "/", Data: []interface{}{"a"}, Id: intPtr(10)},
+		{Type: PacketEvent, Namespace: "/chat", Data: []interface{}{"a", 1.5}, Id: intPtr(1234567)},
+		{Type: PacketAck, Namespace: "/", Data: []interface{}{}, Id: intPtr(10)},
+		{Type: PacketAck, Namespace: "/", Data: []interface{}{nil}, Id: intPtr(99)},
+		{Type: PacketConnectError, Namespace: "/admin", Data: map[string]interface{}{"message": "no", "data": nil}},
+		{Type: PacketEvent, Namespace: "/", Data: many, Id: intPtr(10)},
+		{Type: PacketAck, Namespace: "/files", Data: []interface{}{map[string]interface{}{"a": []byte{1}, "b": []interface{}{[]byte{2}, []byte{3}}}}, Id: intPtr(11)},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			msgs, err := DefaultParser.Encode(tt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			attachments := make([][]byte, 0, len(msgs)-1)
+			for _, msg := range msgs[1:] {
+				attachments = append(attachments, msg.Data)
+			}
+			got, err := decodeAll(t, string(msgs[0].Data), attachments...)
+			if err != nil {
+				t.Fatalf("%s: %v", msgs[0].Data, err)
+			}
+
+			want := *tt
+			want.DataKind = reflect.Invalid
+			if want.Data != nil {
+				want.DataKind = reflect.ValueOf(want.Data).Kind()
+				want.Data = normalizeExceptBinary(t, want.Data)
+			}
+			if len(attachments) > 0 {
+				want.Type += PacketBinaryEvent - PacketEvent
+				want.NumOfAttachments = len(attachments)
+				got.Data = normalizeExceptBinary(t, got.Data)
+			}
+			materialize(t, DefaultParser, got)
+			if !reflect.DeepEqual(got, &want) {
+				t.Errorf("%s: got %+v, want %+v", msgs[0].Data, got, &want)
+			}
+		})
+	}
+}
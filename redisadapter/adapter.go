@@ -0,0 +1,720 @@
+// Package redisadapter provides a socket.igo adapter broadcasting through
+// Redis pub/sub. It speaks the protocol of @socket.io/redis-adapter, so Go
+// and Node.js servers can share the same Redis instance.
+package redisadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	socketigo "github.com/taogames/socket.igo"
+	"github.com/taogames/socket.igo/internal/wire"
+	"go.uber.org/zap"
+)
+
+var ErrRequestTimeout = errors.New("timeout reached while waiting for response")
+
+type Option func(adp *Adapter)
+
+// WithKey sets the prefix of the Redis channels, "socket.io" by default.
+func WithKey(key string) Option {
+	return func(adp *Adapter) {
+		adp.key = key
+	}
+}
+
+// WithRequestsTimeout bounds how long requests to other servers, like
+// FetchSockets, wait for their responses.
+func WithRequestsTimeout(timeout time.Duration) Option {
+	return func(adp *Adapter) {
+		adp.requestsTimeout = timeout
+	}
+}
+
+// WithPublishOnSpecificResponseChannel publishes responses on a channel
+// dedicated to the requesting server instead of the shared one.
+func WithPublishOnSpecificResponseChannel(on bool) Option {
+	return func(adp *Adapter) {
+		adp.publishOnSpecificResponseChannel = on
+	}
+}
+
+type Adapter struct {
+	*socketigo.InMemoryAdapter
+
+	nsp    *socketigo.Namespace
+	client redis.UniversalClient
+	pubsub *redis.PubSub
+	uid    string
+
+	key                              string
+	requestsTimeout                  time.Duration
+	publishOnSpecificResponseChannel bool
+
+	channel                 string
+	requestChannel          string
+	responseChannel         string
+	specificResponseChannel string
+
+	lock        sync.Mutex
+	requests    map[string]*pendingRequest
+	ackRequests map[string]*ackRequest
+
+	logger *zap.SugaredLogger
+}
+
+// Distributed marks the adapter as a socketigo.DistributedAdapter, sessions
+// are not shared with the other servers.
+func (adp *Adapter) Distributed() {}
+
+type pendingRequest struct {
+	typ       requestType
+	numSub    int
+	msgCount  int
+	sockets   []*socketigo.RemoteSocket
+	rooms     map[string]struct{}
+	responses []*socketigo.AckResponse
+	done      chan struct{}
+}
+
+type ackRequest struct {
+	clientCount func(n int)
+	ack         func(sid string, resp *socketigo.AckResponse)
+	anonymous   int
+}
+
+func NewAdapterIniter(client redis.UniversalClient, opts ...Option) socketigo.AdapterIniter {
+	return func(nsp *socketigo.Namespace) socketigo.Adapter {
+		return New(nsp, client, opts...)
+	}
+}
+
+func New(nsp *socketigo.Namespace, client redis.UniversalClient, opts ...Option) *Adapter {
+	adp := &Adapter{
+		InMemoryAdapter: socketigo.NewInMemoryAdapter(nsp),
+		nsp:             nsp,
+		client:          client,
+		uid:             wire.NewUid(),
+		key:             "socket.io",
+		requestsTimeout: 5 * time.Second,
+		requests:        make(map[string]*pendingRequest),
+		ackRequests:     make(map[string]*ackRequest),
+	}
+
+	for _, o := range opts {
+		o(adp)
+	}
+
+	adp.logger = nsp.Logger().With("Adapter", "Redis", "uid", adp.uid)
+	adp.channel = adp.key + "#" + nsp.Name() + "#"
+	adp.requestChannel = adp.key + "-request#" + nsp.Name() + "#"
+	adp.responseChannel = adp.key + "-response#" + nsp.Name() + "#"
+	adp.specificResponseChannel = adp.responseChannel + adp.uid + "#"
+
+	ctx := context.Background()
+	adp.pubsub = client.PSubscribe(ctx, adp.channel+"*")
+	if err := adp.pubsub.Subscribe(ctx, adp.requestChannel, adp.responseChannel, adp.specificResponseChannel); err != nil {
+		adp.logger.Error("Subscribe: ", err)
+	}
+	go adp.run()
+
+	return adp
+}
+
+// Close unsubscribes the adapter from Redis.
+func (adp *Adapter) Close() error {
+	return adp.pubsub.Close()
+}
+
+func (adp *Adapter) run() {
+	for msg := range adp.pubsub.Channel() {
+		if msg.Pattern != "" {
+			adp.onMessage(msg.Channel, []byte(msg.Payload))
+		} else {
+			adp.onRequest(msg.Channel, []byte(msg.Payload))
+		}
+	}
+}
+
+// publish returns the number of subscribers which received the message.
+func (adp *Adapter) publish(channel string, msg []byte) int {
+	receivers, err := adp.client.Publish(context.Background(), channel, msg).Result()
+	if err != nil {
+		adp.logger.Errorf("Publish on %s: %v", channel, err)
+	}
+	return int(receivers)
+}
+
+func (adp *Adapter) publishJSON(channel string, v interface{}) int {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		adp.logger.Errorf("Encode %+v: %v", v, err)
+		return 0
+	}
+	return adp.publish(channel, msg)
+}
+
+func (adp *Adapter) publishMsgpack(channel string, v interface{}) int {
+	msg, err := wire.Marshal(v)
+	if err != nil {
+		adp.logger.Errorf("Encode %+v: %v", v, err)
+		return 0
+	}
+	return adp.publish(channel, msg)
+}
+
+func (adp *Adapter) publishResponse(req *request, v interface{}, binary bool) {
+	channel := adp.responseChannel
+	if adp.publishOnSpecificResponseChannel {
+		channel += req.Uid + "#"
+	}
+
+	if binary {
+		adp.publishMsgpack(channel, v)
+	} else {
+		adp.publishJSON(channel, v)
+	}
+}
+
+func (adp *Adapter) Broadcast(packet *socketigo.Packet, opts *socketigo.BroadcastOptions) {
+	if raw, ok := wire.FromOptions(opts); ok && !opts.Local {
+		channel := adp.channel
+		if len(raw.Rooms) == 1 {
+			channel += raw.Rooms[0] + "#"
+		}
+		adp.publishMsgpack(channel, []interface{}{adp.uid, wire.FromPacket(packet), raw})
+	}
+
+	adp.InMemoryAdapter.Broadcast(packet, opts)
+}
+
+func (adp *Adapter) BroadcastWithAck(packet *socketigo.Packet, opts *socketigo.BroadcastOptions, clientCount func(n int), ack func(sid string, resp *socketigo.AckResponse)) int {
+	servers := 1
+	if raw, ok := wire.FromOptions(opts); ok && !opts.Local {
+		requestId := wire.NewUid()
+
+		adp.lock.Lock()
+		adp.ackRequests[requestId] = &ackRequest{clientCount: clientCount, ack: ack}
+		adp.lock.Unlock()
+
+		// This server receives its own request too, and ignores it.
+		receivers := adp.publishMsgpack(adp.requestChannel, &request{
+			Uid:       adp.uid,
+			RequestId: requestId,
+			Type:      requestBroadcast,
+			Packet:    wire.FromPacket(packet),
+			Opts:      raw,
+		})
+		if receivers > servers {
+			servers = receivers
+		}
+
+		// There is no telling whether every client answered, so the request
+		// is simply forgotten once the acknowledgements expired.
+		timeout := opts.Timeout
+		if timeout <= 0 {
+			timeout = adp.requestsTimeout
+		}
+		time.AfterFunc(timeout, func() {
+			adp.lock.Lock()
+			delete(adp.ackRequests, requestId)
+			adp.lock.Unlock()
+		})
+	}
+
+	adp.InMemoryAdapter.BroadcastWithAck(packet, opts, clientCount, ack)
+	return servers
+}
+
+func (adp *Adapter) FetchSockets(opts *socketigo.BroadcastOptions) ([]*socketigo.RemoteSocket, error) {
+	local, err := adp.InMemoryAdapter.FetchSockets(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := wire.FromOptions(opts)
+	if !ok {
+		return local, nil
+	}
+	numSub, err := adp.numSub()
+	if err != nil {
+		return local, err
+	}
+	if numSub <= 1 {
+		return local, nil
+	}
+
+	requestId := wire.NewUid()
+	req := &pendingRequest{
+		typ:      requestRemoteFetch,
+		numSub:   numSub,
+		msgCount: 1,
+		sockets:  local,
+		done:     make(chan struct{}),
+	}
+
+	adp.lock.Lock()
+	adp.requests[requestId] = req
+	adp.lock.Unlock()
+
+	adp.publishJSON(adp.requestChannel, &request{
+		Uid:       adp.uid,
+		RequestId: requestId,
+		Type:      requestRemoteFetch,
+		Opts:      raw,
+	})
+
+	timer := time.NewTimer(adp.requestsTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-req.done:
+		return req.sockets, nil
+	case <-timer.C:
+		adp.lock.Lock()
+		defer adp.lock.Unlock()
+		delete(adp.requests, requestId)
+		return req.sockets, fmt.Errorf("fetchSockets: %w", ErrRequestTimeout)
+	}
+}
+
+// AllRooms returns the rooms with at least one socket on any server.
+func (adp *Adapter) AllRooms() (map[string]struct{}, error) {
+	local, err := adp.InMemoryAdapter.AllRooms()
+	if err != nil {
+		return nil, err
+	}
+
+	numSub, err := adp.numSub()
+	if err != nil {
+		return local, err
+	}
+	if numSub <= 1 {
+		return local, nil
+	}
+
+	requestId := wire.NewUid()
+	req := &pendingRequest{
+		typ:      requestAllRooms,
+		numSub:   numSub,
+		msgCount: 1,
+		rooms:    local,
+		done:     make(chan struct{}),
+	}
+
+	adp.lock.Lock()
+	adp.requests[requestId] = req
+	adp.lock.Unlock()
+
+	adp.publishJSON(adp.requestChannel, &request{
+		Uid:       adp.uid,
+		RequestId: requestId,
+		Type:      requestAllRooms,
+	})
+
+	timer := time.NewTimer(adp.requestsTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-req.done:
+		return req.rooms, nil
+	case <-timer.C:
+		adp.lock.Lock()
+		defer adp.lock.Unlock()
+		delete(adp.requests, requestId)
+		return req.rooms, fmt.Errorf("allRooms: %w", ErrRequestTimeout)
+	}
+}
+
+func (adp *Adapter) AddSockets(opts *socketigo.BroadcastOptions, rooms []string) {
+	if raw, ok := wire.FromOptions(opts); ok {
+		adp.publishJSON(adp.requestChannel, &request{
+			Uid:   adp.uid,
+			Type:  requestRemoteJoin,
+			Opts:  raw,
+			Rooms: rooms,
+		})
+	}
+	adp.InMemoryAdapter.AddSockets(opts, rooms)
+}
+
+func (adp *Adapter) DelSockets(opts *socketigo.BroadcastOptions, rooms []string) {
+	if raw, ok := wire.FromOptions(opts); ok {
+		adp.publishJSON(adp.requestChannel, &request{
+			Uid:   adp.uid,
+			Type:  requestRemoteLeave,
+			Opts:  raw,
+			Rooms: rooms,
+		})
+	}
+	adp.InMemoryAdapter.DelSockets(opts, rooms)
+}
+
+func (adp *Adapter) DisconnectSockets(opts *socketigo.BroadcastOptions, close bool) {
+	if raw, ok := wire.FromOptions(opts); ok {
+		adp.publishJSON(adp.requestChannel, &request{
+			Uid:   adp.uid,
+			Type:  requestRemoteDisconnect,
+			Opts:  raw,
+			Close: close,
+		})
+	}
+	adp.InMemoryAdapter.DisconnectSockets(opts, close)
+}
+
+// ServerCount returns the number of servers subscribed to the namespace.
+func (adp *Adapter) ServerCount() int {
+	n, err := adp.numSub()
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (adp *Adapter) ServerSideEmit(packet []interface{}) {
+	adp.publishMsgpack(adp.requestChannel, &request{
+		Uid:  adp.uid,
+		Type: requestServerSideEmit,
+		Data: wire.Normalize(packet).([]interface{}),
+	})
+}
+
+// ServerSideEmitWithAck waits for the other servers subscribed to the
+// namespace, at most for the requests timeout when ctx has no deadline.
+func (adp *Adapter) ServerSideEmitWithAck(ctx context.Context, packet []interface{}) ([]*socketigo.AckResponse, error) {
+	numSub, err := adp.numSub()
+	if err != nil {
+		return nil, err
+	}
+	if numSub <= 1 {
+		return nil, nil
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, adp.requestsTimeout)
+		defer cancel()
+	}
+
+	requestId := wire.NewUid()
+	req := &pendingRequest{
+		typ:      requestServerSideEmit,
+		numSub:   numSub,
+		msgCount: 1,
+		done:     make(chan struct{}),
+	}
+
+	adp.lock.Lock()
+	adp.requests[requestId] = req
+	adp.lock.Unlock()
+
+	adp.publishMsgpack(adp.requestChannel, &request{
+		Uid:       adp.uid,
+		RequestId: requestId,
+		Type:      requestServerSideEmit,
+		Data:      wire.Normalize(packet).([]interface{}),
+	})
+
+	select {
+	case <-req.done:
+		return req.responses, nil
+	case <-ctx.Done():
+		adp.lock.Lock()
+		defer adp.lock.Unlock()
+		delete(adp.requests, requestId)
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return req.responses, fmt.Errorf("serverSideEmit: %w: missing %d responses", ErrRequestTimeout, req.numSub-req.msgCount)
+		}
+		return req.responses, ctx.Err()
+	}
+}
+
+func (adp *Adapter) numSub() (int, error) {
+	res, err := adp.client.PubSubNumSub(context.Background(), adp.requestChannel).Result()
+	if err != nil {
+		adp.logger.Error("PubSubNumSub: ", err)
+		return 0, err
+	}
+	return int(res[adp.requestChannel]), nil
+}
+
+func (adp *Adapter) hasRequest(requestId string) bool {
+	adp.lock.Lock()
+	defer adp.lock.Unlock()
+
+	_, ok := adp.requests[requestId]
+	if !ok {
+		_, ok = adp.ackRequests[requestId]
+	}
+	return ok
+}
+
+func (adp *Adapter) onMessage(channel string, msg []byte) {
+	if !strings.HasPrefix(channel, adp.channel) {
+		return
+	}
+	if room := strings.TrimSuffix(channel[len(adp.channel):], "#"); room != "" {
+		if len(adp.InMemoryAdapter.Sockets([]string{room})) == 0 {
+			return
+		}
+	}
+
+	var (
+		uid    string
+		packet wire.Packet
+		opts   wire.Options
+	)
+	dec := wire.NewDecoder(msg)
+	if n, err := dec.DecodeArrayLen(); err != nil || n != 3 {
+		adp.logger.Errorf("Invalid broadcast message on %s: %v", channel, err)
+		return
+	}
+	if err := dec.Decode(&uid); err != nil {
+		adp.logger.Error("Decode broadcast uid: ", err)
+		return
+	}
+	if uid == adp.uid {
+		return
+	}
+	if err := dec.Decode(&packet); err != nil {
+		adp.logger.Error("Decode broadcast packet: ", err)
+		return
+	}
+	if err := dec.Decode(&opts); err != nil {
+		adp.logger.Error("Decode broadcast options: ", err)
+		return
+	}
+
+	if packet.Nsp == "" {
+		packet.Nsp = socketigo.MainNamespace
+	}
+	if packet.Nsp != adp.nsp.Name() {
+		return
+	}
+
+	adp.InMemoryAdapter.Broadcast(packet.ToPacket(), opts.ToOptions())
+}
+
+func (adp *Adapter) onRequest(channel string, msg []byte) {
+	if strings.HasPrefix(channel, adp.responseChannel) {
+		adp.onResponse(msg)
+		return
+	}
+	if channel != adp.requestChannel {
+		return
+	}
+
+	var req request
+	if err := decode(msg, &req); err != nil {
+		adp.logger.Error("Decode request: ", err)
+		return
+	}
+	adp.logger.Debugf("Request %+v", req)
+
+	switch req.Type {
+	case requestSockets:
+		if adp.hasRequest(req.RequestId) {
+			return
+		}
+		var rooms []string
+		if req.Opts != nil {
+			rooms = req.Opts.Rooms
+		} else {
+			rooms = req.Rooms
+		}
+		sids := adp.InMemoryAdapter.Sockets(rooms)
+		resp := &socketsResponse{RequestId: req.RequestId, Sockets: make([]string, 0, len(sids))}
+		for sid := range sids {
+			resp.Sockets = append(resp.Sockets, sid)
+		}
+		adp.publishResponse(&req, resp, false)
+
+	case requestAllRooms:
+		if adp.hasRequest(req.RequestId) {
+			return
+		}
+		rooms, _ := adp.InMemoryAdapter.AllRooms()
+		resp := &roomsResponse{RequestId: req.RequestId, Rooms: make([]string, 0, len(rooms))}
+		for room := range rooms {
+			resp.Rooms = append(resp.Rooms, room)
+		}
+		adp.publishResponse(&req, resp, false)
+
+	case requestRemoteJoin, requestRemoteLeave, requestRemoteDisconnect:
+		if req.Uid == adp.uid {
+			return
+		}
+		opts := &socketigo.BroadcastOptions{Includes: []string{req.Sid}}
+		rooms := []string{req.Room}
+		if req.Opts != nil {
+			opts, rooms = req.Opts.ToOptions(), req.Rooms
+		} else if len(adp.InMemoryAdapter.Sockets([]string{req.Sid})) == 0 {
+			return
+		}
+
+		respond := func() {
+			if req.Opts == nil {
+				adp.publishResponse(&req, &responseHeader{RequestId: req.RequestId}, false)
+			}
+		}
+
+		switch req.Type {
+		case requestRemoteJoin:
+			adp.InMemoryAdapter.AddSockets(opts, rooms)
+		case requestRemoteLeave:
+			adp.InMemoryAdapter.DelSockets(opts, rooms)
+		case requestRemoteDisconnect:
+			// The disconnect handlers run off the subscription goroutine, which
+			// must keep receiving the responses they may wait for.
+			go func() {
+				adp.InMemoryAdapter.DisconnectSockets(opts, req.Close)
+				respond()
+			}()
+			return
+		}
+		respond()
+
+	case requestRemoteFetch:
+		if adp.hasRequest(req.RequestId) || req.Opts == nil {
+			return
+		}
+		sockets, err := adp.InMemoryAdapter.FetchSockets(req.Opts.ToOptions())
+		if err != nil {
+			adp.logger.Error("FetchSockets: ", err)
+			return
+		}
+		for _, socket := range sockets {
+			socket.Data = wire.Normalize(socket.Data).(map[string]interface{})
+		}
+		adp.publishResponse(&req, &fetchResponse{RequestId: req.RequestId, Sockets: sockets}, false)
+
+	case requestBroadcast:
+		if adp.hasRequest(req.RequestId) || req.Packet == nil || req.Opts == nil {
+			return
+		}
+		adp.InMemoryAdapter.BroadcastWithAck(req.Packet.ToPacket(), req.Opts.ToOptions(), func(n int) {
+			adp.publishResponse(&req, &clientCountResponse{
+				Type:        requestBroadcastClientCount,
+				RequestId:   req.RequestId,
+				ClientCount: n,
+			}, false)
+		}, func(sid string, resp *socketigo.AckResponse) {
+			var arg interface{}
+			if args := resp.Args(); len(args) > 0 {
+				arg = wire.Normalize(args[0])
+			}
+			adp.publishResponse(&req, &ackResponse{
+				Type:      requestBroadcastAck,
+				RequestId: req.RequestId,
+				Packet:    arg,
+				Sid:       sid,
+			}, true)
+		})
+
+	case requestServerSideEmit:
+		if req.Uid == adp.uid {
+			return
+		}
+		// As for disconnections, the handlers may wait for responses.
+		if req.RequestId == "" {
+			go adp.nsp.HandleServerSideEmit(req.Data, nil)
+			return
+		}
+		go adp.nsp.HandleServerSideEmit(req.Data, func(args ...interface{}) {
+			var arg interface{}
+			if len(args) > 0 {
+				arg = wire.Normalize(args[0])
+			}
+			adp.publishResponse(&req, &serverSideEmitResponse{
+				Type:      requestServerSideEmit,
+				RequestId: req.RequestId,
+				Data:      arg,
+			}, true)
+		})
+
+	default:
+		adp.logger.Debugf("Unsupported request type %d", req.Type)
+	}
+}
+
+func (adp *Adapter) onResponse(msg []byte) {
+	var header responseHeader
+	if err := decode(msg, &header); err != nil {
+		adp.logger.Error("Decode response: ", err)
+		return
+	}
+
+	adp.lock.Lock()
+	defer adp.lock.Unlock()
+
+	if ackReq, ok := adp.ackRequests[header.RequestId]; ok {
+		switch header.Type {
+		case requestBroadcastClientCount:
+			var resp clientCountResponse
+			if err := decode(msg, &resp); err != nil {
+				adp.logger.Error("Decode client count response: ", err)
+				return
+			}
+			ackReq.clientCount(resp.ClientCount)
+		case requestBroadcastAck:
+			var resp ackResponse
+			if err := decode(msg, &resp); err != nil {
+				adp.logger.Error("Decode ack response: ", err)
+				return
+			}
+			sid := resp.Sid
+			if sid == "" {
+				ackReq.anonymous++
+				sid = fmt.Sprintf("%s#%d", header.RequestId, ackReq.anonymous)
+			}
+			ackReq.ack(sid, socketigo.NewAckResponse(adp.nsp, resp.Packet))
+		}
+		return
+	}
+
+	req, ok := adp.requests[header.RequestId]
+	if !ok {
+		return
+	}
+
+	switch req.typ {
+	case requestRemoteFetch:
+		var resp fetchResponse
+		if err := decode(msg, &resp); err != nil {
+			adp.logger.Error("Decode fetch response: ", err)
+			return
+		}
+		req.msgCount++
+		req.sockets = append(req.sockets, resp.Sockets...)
+	case requestAllRooms:
+		var resp roomsResponse
+		if err := decode(msg, &resp); err != nil {
+			adp.logger.Error("Decode rooms response: ", err)
+			return
+		}
+		req.msgCount++
+		for _, room := range resp.Rooms {
+			req.rooms[room] = struct{}{}
+		}
+	case requestServerSideEmit:
+		var resp serverSideEmitResponse
+		if err := decode(msg, &resp); err != nil {
+			adp.logger.Error("Decode server side emit response: ", err)
+			return
+		}
+		req.msgCount++
+		req.responses = append(req.responses, socketigo.NewAckResponse(adp.nsp, resp.Data))
+	}
+
+	if req.msgCount >= req.numSub {
+		delete(adp.requests, header.RequestId)
+		close(req.done)
+	}
+}
@@ -0,0 +1,282 @@
+package redisadapter_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/taogames/engine.igo/utils/idgen"
+	socketigo "github.com/taogames/socket.igo"
+	"github.com/taogames/socket.igo/internal/sockettest"
+	"github.com/taogames/socket.igo/redisadapter"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	idgen.Default = &sockettest.IDGenerator{}
+	os.Exit(m.Run())
+}
+
+type node struct {
+	srv  *socketigo.Server
+	http *httptest.Server
+}
+
+// newCluster starts n servers sharing one Redis. Their sockets join the
+// room "room".
+func newCluster(t *testing.T, n int) []*node {
+	t.Helper()
+	mr := miniredis.RunT(t)
+
+	nodes := make([]*node, n)
+	for i := range nodes {
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { client.Close() })
+
+		srv, err := socketigo.NewServer(
+			socketigo.WithAdapter(redisadapter.NewAdapterIniter(client, redisadapter.WithRequestsTimeout(time.Second))),
+			socketigo.WithLogger(zap.NewNop().Sugar()),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.Of("/").OnConnection(func(socket *socketigo.Socket) {
+			socket.Join("room")
+		})
+		go srv.Accept()
+
+		ts := httptest.NewServer(srv)
+		t.Cleanup(func() {
+			srv.Close()
+			ts.Close()
+		})
+		nodes[i] = &node{srv: srv, http: ts}
+	}
+
+	// Wait for every adapter to be subscribed.
+	deadline := time.Now().Add(time.Second)
+	for nodes[0].srv.Of("/").Adapter().ServerCount() < n {
+		if time.Now().After(deadline) {
+			t.Fatal("servers did not subscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nodes
+}
+
+func connect(t *testing.T, n *node) *sockettest.Client {
+	t.Helper()
+	c, err := sockettest.Dial(n.http.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	if reply, err := c.Connect("/"); err != nil || !strings.HasPrefix(reply, "0{") {
+		t.Fatalf("connect: %q, %v", reply, err)
+	}
+	return c
+}
+
+func expect(t *testing.T, c *sockettest.Client, want string) {
+	t.Helper()
+	got, err := c.Expect(time.Second)
+	if err != nil {
+		t.Fatalf("waiting for %s: %v", want, err)
+	}
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	nodes := newCluster(t, 2)
+	c1 := connect(t, nodes[0])
+	c2 := connect(t, nodes[1])
+
+	nodes[0].srv.To("room").Emit("hello", "world")
+	expect(t, c1, `2["hello","world"]`)
+	expect(t, c2, `2["hello","world"]`)
+
+	nodes[1].srv.Emit("all", 1)
+	expect(t, c1, `2["all",1]`)
+	expect(t, c2, `2["all",1]`)
+}
+
+func TestBroadcastWithAck(t *testing.T) {
+	nodes := newCluster(t, 2)
+	clients := []*sockettest.Client{connect(t, nodes[0]), connect(t, nodes[1])}
+
+	type result struct {
+		responses map[string]*socketigo.AckResponse
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		responses, err := nodes[0].srv.To("room").Timeout(time.Second).EmitWithAck(context.Background(), "ping")
+		done <- result{responses, err}
+	}()
+
+	for i, c := range clients {
+		packet, err := c.Expect(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, ok := strings.CutSuffix(strings.TrimPrefix(packet, "2"), `["ping"]`)
+		if !ok || id == "" {
+			t.Fatalf("unexpected packet %s", packet)
+		}
+		if err := c.Send("3" + id + `["pong` + string(rune('0'+i)) + `"]`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if len(r.responses) != 2 {
+		t.Fatalf("got %d responses, want 2", len(r.responses))
+	}
+	var got []string
+	for _, resp := range r.responses {
+		var s string
+		if err := resp.Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, s)
+	}
+	sort.Strings(got)
+	if got[0] != "pong0" || got[1] != "pong1" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestFetchSockets(t *testing.T) {
+	nodes := newCluster(t, 2)
+	connect(t, nodes[0])
+	connect(t, nodes[1])
+	connect(t, nodes[1])
+
+	sockets, err := nodes[0].srv.Of("/").FetchSockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sockets) != 3 {
+		t.Fatalf("got %d sockets, want 3", len(sockets))
+	}
+	for _, socket := range sockets {
+		rooms := strings.Join(socket.Rooms, ",")
+		if !strings.Contains(rooms, "room") || !strings.Contains(rooms, socket.Id) {
+			t.Errorf("socket %s has rooms %v", socket.Id, socket.Rooms)
+		}
+	}
+
+	sockets, err = nodes[1].srv.In("other").FetchSockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sockets) != 0 {
+		t.Errorf("got %d sockets in an empty room", len(sockets))
+	}
+}
+
+func TestAllRooms(t *testing.T) {
+	nodes := newCluster(t, 2)
+	connect(t, nodes[0])
+	connect(t, nodes[1])
+
+	want := []string{"room"}
+	for _, n := range nodes {
+		for sid := range n.srv.Of("/").Adapter().Sockets(nil) {
+			want = append(want, sid)
+		}
+	}
+	sort.Strings(want)
+
+	rooms, err := nodes[0].srv.Of("/").Rooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rooms, ",") != strings.Join(want, ",") {
+		t.Errorf("got rooms %v, want %v", rooms, want)
+	}
+}
+
+func TestServerSideEmit(t *testing.T) {
+	nodes := newCluster(t, 3)
+
+	received := make(chan string, 2)
+	for _, n := range nodes[1:] {
+		n.srv.OnServerSideEmit("hello", func(s string, ack func(...interface{})) {
+			received <- s
+			ack("hi " + s)
+		})
+	}
+	nodes[0].srv.OnServerSideEmit("hello", func(string, func(...interface{})) {
+		t.Error("the sender got its own event")
+	})
+
+	nodes[0].srv.ServerSideEmit("hello", "world")
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-received:
+			if s != "world" {
+				t.Errorf("got %s", s)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("server side event not received")
+		}
+	}
+
+	responses, err := nodes[0].srv.ServerSideEmitWithAck(context.Background(), "hello", "you")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("got %d responses, want 2", len(responses))
+	}
+	for _, resp := range responses {
+		var s string
+		if err := resp.Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		if s != "hi you" {
+			t.Errorf("got %s", s)
+		}
+	}
+}
+
+// A server side handler can itself wait for acknowledgements, which arrive
+// on the subscription its event was received on.
+func TestNestedServerSideEmitWithAck(t *testing.T) {
+	nodes := newCluster(t, 2)
+
+	nodes[0].srv.OnServerSideEmit("inner", func(ack func(...interface{})) {
+		ack("inner")
+	})
+	nodes[1].srv.OnServerSideEmit("outer", func(ack func(...interface{})) {
+		responses, err := nodes[1].srv.ServerSideEmitWithAck(context.Background(), "inner")
+		if err != nil || len(responses) != 1 {
+			ack("failed")
+			return
+		}
+		var s string
+		responses[0].Decode(&s)
+		ack("outer " + s)
+	})
+
+	responses, err := nodes[0].srv.ServerSideEmitWithAck(context.Background(), "outer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if len(responses) != 1 || responses[0].Decode(&s) != nil || s != "outer inner" {
+		t.Errorf("got %v, %s", responses, s)
+	}
+}
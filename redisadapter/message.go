@@ -0,0 +1,92 @@
+package redisadapter
+
+import (
+	"encoding/json"
+
+	socketigo "github.com/taogames/socket.igo"
+	"github.com/taogames/socket.igo/internal/wire"
+)
+
+// requestType mirrors the request types of @socket.io/redis-adapter.
+type requestType int
+
+const (
+	requestSockets requestType = iota
+	requestAllRooms
+	requestRemoteJoin
+	requestRemoteLeave
+	requestRemoteDisconnect
+	requestRemoteFetch
+	requestServerSideEmit
+	requestBroadcast
+	requestBroadcastClientCount
+	requestBroadcastAck
+)
+
+type request struct {
+	Uid       string      `json:"uid"`
+	RequestId string      `json:"requestId,omitempty"`
+	Type      requestType `json:"type"`
+
+	Opts   *wire.Options `json:"opts,omitempty"`
+	Rooms  []string      `json:"rooms,omitempty"`
+	Sid    string        `json:"sid,omitempty"`
+	Room   string        `json:"room,omitempty"`
+	Close  bool          `json:"close,omitempty"`
+	Packet *wire.Packet  `json:"packet,omitempty"`
+	Data   []interface{} `json:"data,omitempty"`
+}
+
+// responseHeader holds the fields shared by every response, read first to
+// find out how to decode the rest.
+type responseHeader struct {
+	Type      requestType `json:"type"`
+	RequestId string      `json:"requestId"`
+}
+
+type socketsResponse struct {
+	RequestId string   `json:"requestId"`
+	Sockets   []string `json:"sockets"`
+}
+
+type roomsResponse struct {
+	RequestId string   `json:"requestId"`
+	Rooms     []string `json:"rooms"`
+}
+
+type fetchResponse struct {
+	RequestId string                    `json:"requestId"`
+	Sockets   []*socketigo.RemoteSocket `json:"sockets"`
+}
+
+type clientCountResponse struct {
+	Type        requestType `json:"type"`
+	RequestId   string      `json:"requestId"`
+	ClientCount int         `json:"clientCount"`
+}
+
+// ackResponse carries one client acknowledgement. Sid is not part of the
+// reference protocol and is missing from responses sent by Node.js servers.
+type ackResponse struct {
+	Type      requestType `json:"type"`
+	RequestId string      `json:"requestId"`
+	Packet    interface{} `json:"packet"`
+	Sid       string      `json:"sid,omitempty"`
+}
+
+// serverSideEmitResponse carries the first acknowledgement argument only, as
+// the reference implementation does.
+type serverSideEmitResponse struct {
+	Type      requestType `json:"type"`
+	RequestId string      `json:"requestId"`
+	Data      interface{} `json:"data"`
+}
+
+// decode reads a request or response, which the reference implementation
+// sends either as JSON or as MessagePack.
+func decode(msg []byte, v interface{}) error {
+	if len(msg) > 0 && msg[0] == '{' {
+		return json.Unmarshal(msg, v)
+	}
+	return wire.NewDecoder(msg).Decode(v)
+}
@@ -0,0 +1,38 @@
+package socketigo
+
+// RemoteSocket is a snapshot of a socket returned by FetchSockets. The socket
+// may live on another server; its methods go through the adapter.
+type RemoteSocket struct {
+	Id        string                 `json:"id"`
+	Handshake Handshake              `json:"handshake"`
+	Rooms     []string               `json:"rooms"`
+	Data      map[string]interface{} `json:"data"`
+
+	nsp *Namespace
+}
+
+func newRemoteSocket(s *Socket) *RemoteSocket {
+	return &RemoteSocket{
+		Id:        s.Id,
+		Handshake: s.Handshake,
+		Rooms:     s.Rooms(),
+		Data:      s.customCopy(),
+		nsp:       s.nsp,
+	}
+}
+
+func (rs *RemoteSocket) Emit(eName string, args ...interface{}) {
+	rs.nsp.To(rs.Id).Emit(eName, args...)
+}
+
+func (rs *RemoteSocket) Join(rooms ...string) {
+	rs.nsp.In(rs.Id).SocketsJoin(rooms...)
+}
+
+func (rs *RemoteSocket) Leave(rooms ...string) {
+	rs.nsp.In(rs.Id).SocketsLeave(rooms...)
+}
+
+func (rs *RemoteSocket) Disconnect(closeConn bool) {
+	rs.nsp.In(rs.Id).DisconnectSockets(closeConn)
+}
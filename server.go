@@ -1,8 +1,10 @@
 package socketigo
 
 import (
-	"encoding/json"
+	"errors"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	engineigo "github.com/taogames/engine.igo"
@@ -30,6 +32,30 @@ func WithMaxPayload(payload int64) ServerOption {
 	}
 }
 
+func WithAdapter(init AdapterIniter) ServerOption {
+	return func(s *Server) {
+		s.adapterInit = init
+	}
+}
+
+func WithParser(parser Parser) ServerOption {
+	return func(s *Server) {
+		s.parser = parser
+	}
+}
+
+// WithConnectionStateRecovery lets clients disconnected for less than
+// maxDisconnectionDuration recover their socket id, rooms, custom data and
+// the events they missed. Recovered sockets skip the middlewares.
+//
+// Sessions and offsets are kept by each server, so NewServer returns
+// ErrRecoveryUnsupported when it is combined with a DistributedAdapter.
+func WithConnectionStateRecovery(maxDisconnectionDuration time.Duration) ServerOption {
+	return func(s *Server) {
+		s.recoveryDuration = maxDisconnectionDuration
+	}
+}
+
 func WithLogger(logger *zap.SugaredLogger) ServerOption {
 	return func(s *Server) {
 		s.logger = logger
@@ -38,21 +64,52 @@ func WithLogger(logger *zap.SugaredLogger) ServerOption {
 
 type Server struct {
 	engine      *engineigo.Server
+	sessions    *engineSessions
 	engineOpts  []engineigo.ServerOption
 	adapterInit AdapterIniter
-	nsps        map[string]*Namespace
 	parser      Parser
 
+	recoveryDuration time.Duration
+	errorMapper      ErrorMapper
+	errorEvent       string
+	onError          ErrorHandler
+
+	dispatchMode dispatchMode
+	queueDepth   int
+	overflow     OverflowPolicy
+	workers      int
+	pool         *workerPool
+
+	nspLock    sync.RWMutex
+	nsps       map[string]*Namespace
+	parentNsps []*ParentNamespace
+
 	logger *zap.SugaredLogger
 
-	closed chan struct{}
+	shutdownEvent []interface{}
+	shuttingDown  atomic.Bool
+	inflight      *inflightCounter
+
+	connLock sync.Mutex
+	conns    map[*Connection]struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
 }
 
+// ErrRecoveryUnsupported is returned by NewServer when connection state
+// recovery is enabled along with a DistributedAdapter.
+var ErrRecoveryUnsupported = errors.New("connection state recovery is not supported by distributed adapters")
+
 // TODO refactor constructor
-func NewServer(opts ...ServerOption) *Server {
+func NewServer(opts ...ServerOption) (*Server, error) {
 	srv := &Server{adapterInit: NewInMemoryAdapterIniter(),
-		nsps:   make(map[string]*Namespace),
-		parser: DefaultParser,
+		nsps:        make(map[string]*Namespace),
+		parser:      DefaultParser,
+		errorMapper: DefaultErrorMapper,
+		inflight:    newInflightCounter(),
+		conns:       make(map[*Connection]struct{}),
+		closed:      make(chan struct{}),
 	}
 
 	for _, o := range opts {
@@ -67,13 +124,37 @@ func NewServer(opts ...ServerOption) *Server {
 		srv.logger = logger.Sugar()
 	}
 
-	srv.engineOpts = append(srv.engineOpts, engineigo.WithLogger(srv.logger))
-	srv.engine = engineigo.NewServer(srv.engineOpts...)
+	if srv.dispatchMode == dispatchPool {
+		srv.pool = newWorkerPool(srv.workers, func(n int) {
+			srv.inflight.Add(-int64(n))
+		})
+	}
+
+	main := NewNamespace(srv, "/")
+	if _, ok := main.adapter.(DistributedAdapter); ok && srv.recoveryDuration > 0 {
+		return nil, ErrRecoveryUnsupported
+	}
+	srv.nsps[main.name] = main
+
+	srv.engine, srv.sessions = newEngine(srv.logger, srv.closed, srv.engineOpts...)
 
-	return srv
+	return srv, nil
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if sid := r.URL.Query().Get("sid"); sid != "" {
+		s.sessions.serve(w, r, sid)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		hs := newHandshake(r)
+		if s.shuttingDown.Load() || !s.sessions.begin(hs) {
+			http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		defer s.sessions.end(hs)
+	}
 	s.engine.ServeHTTP(w, r)
 }
 
@@ -83,48 +164,48 @@ func (s *Server) Accept() {
 		case <-s.closed:
 			return
 		case e := <-s.engine.Accept():
+			handshake := s.sessions.accept(e)
+			if s.shuttingDown.Load() {
+				go s.sessions.close(e)
+				continue
+			}
 			s.logger.Info("Engine.IO connection received")
 			conn := &Connection{
 				session:   e,
 				server:    s,
 				parser:    s.parser,
+				decoder:   s.parser.NewDecoder(),
+				handshake: handshake,
 				socketIds: make(map[string]*Socket),
 				logger:    s.logger.With("Connection", e.ID()),
 			}
+			s.addConn(conn)
 
 			// Init
 			go func() {
 				mt, bs, err := conn.session.ReadMessage()
 				if err != nil {
 					s.logger.Error("conn.session.NextReader(): ", err)
-					return
-				}
-
-				if mt != message.MTText {
-					s.logger.Errorf("first message is %v, not text ", mt)
 					conn.Close()
 					return
 				}
-				packet, err := conn.parser.Decode(&message.Message{Type: mt, Data: bs})
+
+				packet, err := conn.decoder.Decode(&message.Message{Type: mt, Data: bs})
 				if err != nil {
 					s.logger.Error("parser.Decode error: ", err)
 					conn.Close()
 					return
 				}
-				if packet.Type != PacketConnect {
-					s.logger.Errorf("first packet is %v, not connect", packet.Type)
+				if packet == nil || packet.Type != PacketConnect {
+					s.logger.Errorf("first packet is %v, not connect", packet)
 					conn.Close()
 					return
 				}
 
-				nsp, ok := conn.server.nsps[packet.Namespace]
-				if !ok {
-					conn.ConnectError(packet.Namespace, ErrInvalidNamespace)
+				if !conn.connect(packet) {
 					conn.Close()
 					return
 				}
-				handshake, _ := json.Marshal(packet.Data)
-				conn.Connect(nsp, handshake)
 				go conn.Start()
 
 			}()
@@ -133,22 +214,48 @@ func (s *Server) Accept() {
 }
 
 type errMsg struct {
-	Message string `json:"message"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// ConnectError can be passed to a middleware's next function to reject a
+// connection with additional data for the client.
+type ConnectError struct {
+	Message string
+	Data    interface{}
+}
+
+func (e *ConnectError) Error() string {
+	return e.Message
+}
+
+func newErrMsg(err error) errMsg {
+	var ce *ConnectError
+	if errors.As(err, &ce) {
+		return errMsg{Message: ce.Message, Data: ce.Data}
+	}
+	return errMsg{Message: err.Error()}
 }
 
 var ErrInvalidNamespace errMsg = errMsg{
 	Message: "Invalid namespace",
 }
 
-type connReply struct {
-	Sid string `json:"sid"`
+// ErrAlreadyConnected rejects a CONNECT to a namespace the connection has a
+// socket in.
+var ErrAlreadyConnected errMsg = errMsg{
+	Message: "Already connected",
 }
 
-func (s *Server) Close() {
-	close(s.closed)
+type connReply struct {
+	Sid string `json:"sid"`
+	Pid string `json:"pid,omitempty"`
 }
 
 func (s *Server) Of(name string) *Namespace {
+	s.nspLock.Lock()
+	defer s.nspLock.Unlock()
+
 	nsp, ok := s.nsps[name]
 	if ok {
 		return nsp
@@ -159,3 +266,52 @@ func (s *Server) Of(name string) *Namespace {
 
 	return nsp
 }
+
+// namespace looks up a registered namespace, falling back to the parent
+// namespaces which may create a matching child on demand.
+func (s *Server) namespace(name string, auth map[string]interface{}) (*Namespace, bool) {
+	s.nspLock.RLock()
+	nsp, ok := s.nsps[name]
+	parents := s.parentNsps
+	s.nspLock.RUnlock()
+	if ok {
+		return nsp, true
+	}
+
+	for _, pnsp := range parents {
+		if !pnsp.matcher(name, auth) {
+			continue
+		}
+		nsp = pnsp.createChild(name)
+
+		s.nspLock.Lock()
+		if existing, ok := s.nsps[name]; ok {
+			nsp = existing
+		} else {
+			s.nsps[name] = nsp
+		}
+		s.nspLock.Unlock()
+
+		return nsp, true
+	}
+
+	return nil, false
+}
+
+// Emit sends an event to every socket of the main namespace.
+func (s *Server) Emit(eName string, args ...interface{}) {
+	s.Of(MainNamespace).Emit(eName, args...)
+}
+
+func (s *Server) To(rooms ...string) *Broadcast {
+	return s.Of(MainNamespace).To(rooms...)
+}
+
+// In is an alias of To.
+func (s *Server) In(rooms ...string) *Broadcast {
+	return s.To(rooms...)
+}
+
+func (s *Server) Except(rooms ...string) *Broadcast {
+	return s.Of(MainNamespace).Except(rooms...)
+}
@@ -0,0 +1,97 @@
+package socketigo
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+var ackFuncType = reflect.TypeOf(func(...interface{}) {})
+
+// OnServerSideEmit registers a handler for an event sent by another server
+// with ServerSideEmit. Handlers take the event arguments, followed by a
+// func(...interface{}) to acknowledge events sent with ServerSideEmitWithAck,
+// and may take a background context first.
+func (nsp *Namespace) OnServerSideEmit(eName string, h any) *Listener {
+	return nsp.serverSideEh.Register(eName, h)
+}
+
+// ServerSideEmit sends an event to the other servers of the cluster, not to
+// the clients.
+func (nsp *Namespace) ServerSideEmit(eName string, args ...interface{}) {
+	nsp.adapter.ServerSideEmit(append([]interface{}{eName}, args...))
+}
+
+// ServerSideEmitWithAck sends an event to the other servers and waits for
+// each of them to acknowledge it. When ctx expires first, the responses
+// received so far are returned along with the error.
+func (nsp *Namespace) ServerSideEmitWithAck(ctx context.Context, eName string, args ...interface{}) ([]*AckResponse, error) {
+	return nsp.adapter.ServerSideEmitWithAck(ctx, append([]interface{}{eName}, args...))
+}
+
+// HandleServerSideEmit passes an event received from another server to its
+// handler. Adapters call it with a nil ack when the sender does not wait for
+// an acknowledgement.
+func (nsp *Namespace) HandleServerSideEmit(packet []interface{}, ack func(args ...interface{})) {
+	if len(packet) == 0 {
+		return
+	}
+	name, ok := packet[0].(string)
+	if !ok {
+		nsp.logger.Errorf("Invalid server side event %v", packet)
+		return
+	}
+
+	listeners := nsp.serverSideEh.fire(name)
+	if len(listeners) == 0 {
+		nsp.logger.Debugf("No server side handler for %s", name)
+		return
+	}
+
+	p := &Packet{
+		Type:      PacketEvent,
+		Namespace: nsp.name,
+		Data:      packet,
+		DataKind:  reflect.Slice,
+	}
+
+	if ack == nil {
+		ack = func(...interface{}) {}
+	}
+	var once sync.Once
+	onceAck := reflect.ValueOf(func(args ...interface{}) {
+		once.Do(func() { ack(args...) })
+	})
+
+	for _, l := range listeners {
+		args, err := nsp.parser.ParseEventArgs(p, l.types, l.f.Type().IsVariadic())
+		if err != nil {
+			nsp.logger.Errorf("ParseEventArgs %v: %v", packet, err)
+			continue
+		}
+		args = l.pad(args)
+
+		if l.takesAck(len(args)) {
+			args = append(args, onceAck)
+		}
+
+		if err := invoke(nsp.logger, func() error {
+			l.f.Call(l.withContext(context.Background(), args))
+			return nil
+		}); err != nil {
+			nsp.server.reportError(nsp.logger, nil, name, err)
+		}
+	}
+}
+
+func (s *Server) OnServerSideEmit(eName string, h any) *Listener {
+	return s.Of(MainNamespace).OnServerSideEmit(eName, h)
+}
+
+func (s *Server) ServerSideEmit(eName string, args ...interface{}) {
+	s.Of(MainNamespace).ServerSideEmit(eName, args...)
+}
+
+func (s *Server) ServerSideEmitWithAck(ctx context.Context, eName string, args ...interface{}) ([]*AckResponse, error) {
+	return s.Of(MainNamespace).ServerSideEmitWithAck(ctx, eName, args...)
+}
@@ -0,0 +1,154 @@
+package socketigo
+
+import (
+	"strconv"
+	"time"
+)
+
+// Session is the state of a socket kept across a temporary disconnection,
+// see WithConnectionStateRecovery.
+type Session struct {
+	Sid   string
+	Pid   string // Private session id, only known to the client
+	Rooms []string
+	Data  map[string]interface{}
+
+	// MissedPackets holds the payloads of the events the socket missed while
+	// disconnected, as returned by RestoreSession.
+	MissedPackets [][]interface{}
+}
+
+type persistedSession struct {
+	*Session
+	disconnectedAt time.Time
+}
+
+type persistedPacket struct {
+	id        string
+	opts      *BroadcastOptions
+	data      []interface{}
+	emittedAt time.Time
+}
+
+// recoverable reports whether a socket disconnected for this reason may
+// recover its session.
+func (r DisconnectReason) recoverable() bool {
+	switch r {
+	case DRTransportClose, DRTransportError, DRServerShuttingDown:
+		return true
+	}
+	return false
+}
+
+func (adp *InMemoryAdapter) PersistSession(session *Session) {
+	adp.logger.Debugf("Persist session %s of %s", session.Pid, session.Sid)
+
+	adp.sessionLock.Lock()
+	defer adp.sessionLock.Unlock()
+
+	now := time.Now()
+	adp.purge(now)
+	adp.sessions[session.Pid] = &persistedSession{
+		Session:        session,
+		disconnectedAt: now,
+	}
+}
+
+// RestoreSession returns nil when the session is unknown or expired, or
+// when the offset is too old to tell which packets were missed.
+func (adp *InMemoryAdapter) RestoreSession(pid, offset string) (*Session, error) {
+	adp.sessionLock.Lock()
+	defer adp.sessionLock.Unlock()
+
+	session, ok := adp.sessions[pid]
+	if !ok {
+		return nil, nil
+	}
+	if time.Since(session.disconnectedAt) > adp.nsp.server.recoveryDuration {
+		delete(adp.sessions, pid)
+		return nil, nil
+	}
+
+	index := -1
+	for i, packet := range adp.packets {
+		if packet.id == offset {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return nil, nil
+	}
+	delete(adp.sessions, pid)
+
+	restored := *session.Session
+	for _, packet := range adp.packets[index+1:] {
+		if shouldIncludePacket(restored.Rooms, packet.opts) {
+			restored.MissedPackets = append(restored.MissedPackets, packet.data)
+		}
+	}
+	return &restored, nil
+}
+
+// persistPacket appends an offset to the event and keeps it for the sockets
+// that may recover later.
+func (adp *InMemoryAdapter) persistPacket(packet *Packet, opts *BroadcastOptions) {
+	data, ok := packet.Data.([]interface{})
+	if !ok {
+		return
+	}
+
+	adp.sessionLock.Lock()
+	defer adp.sessionLock.Unlock()
+
+	now := time.Now()
+	adp.purge(now)
+
+	adp.offset++
+	id := strconv.FormatUint(adp.offset, 36)
+	data = append(data, id)
+	packet.Data = data
+
+	adp.packets = append(adp.packets, &persistedPacket{
+		id:        id,
+		opts:      opts,
+		data:      data,
+		emittedAt: now,
+	})
+}
+
+// purge drops the expired sessions and packets, at most once a minute.
+func (adp *InMemoryAdapter) purge(now time.Time) {
+	if now.Before(adp.nextPurge) {
+		return
+	}
+	adp.nextPurge = now.Add(time.Minute)
+
+	threshold := now.Add(-adp.nsp.server.recoveryDuration)
+	for pid, session := range adp.sessions {
+		if session.disconnectedAt.Before(threshold) {
+			delete(adp.sessions, pid)
+		}
+	}
+
+	i := 0
+	for i < len(adp.packets) && adp.packets[i].emittedAt.Before(threshold) {
+		i++
+	}
+	adp.packets = append([]*persistedPacket(nil), adp.packets[i:]...)
+}
+
+func shouldIncludePacket(rooms []string, opts *BroadcastOptions) bool {
+	included := opts.IncludeAll
+	for _, room := range rooms {
+		if _, ok := opts.Excludes[room]; ok {
+			return false
+		}
+		for _, include := range opts.Includes {
+			if room == include {
+				included = true
+			}
+		}
+	}
+	return included
+}
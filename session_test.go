@@ -0,0 +1,63 @@
+package socketigo
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestPersistCustomCopy(t *testing.T) {
+	srv, url := newTestServer(t, WithConnectionStateRecovery(time.Minute))
+	sockets := make(chan *Socket, 1)
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		socket.SetCustom("name", "a")
+		sockets <- socket
+	})
+	c := connect(t, url, "/")
+	socket := <-sockets
+
+	srv.Emit("hello")
+	event, err := c.Expect(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []string
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "2")), &data); err != nil || len(data) != 2 {
+		t.Fatalf("event %q: %v", event, err)
+	}
+
+	// Changes made once disconnected are not persisted.
+	socket.OnDisconnect(func(DisconnectReason) {
+		socket.SetCustom("name", "b")
+	})
+	socket.disconnect(false, DRTransportClose)
+
+	session, err := srv.Of("/").Adapter().RestoreSession(socket.pid, data[1])
+	if err != nil || session == nil {
+		t.Fatalf("got %v, %v", session, err)
+	}
+	if name := session.Data["name"]; name != "a" {
+		t.Errorf("got name %v, want a", name)
+	}
+}
+
+type distributedAdapter struct {
+	*InMemoryAdapter
+}
+
+func (distributedAdapter) Distributed() {}
+
+func TestRecoveryWithDistributedAdapter(t *testing.T) {
+	adapter := WithAdapter(func(nsp *Namespace) Adapter { return distributedAdapter{NewInMemoryAdapter(nsp)} })
+	logger := WithLogger(zap.NewNop().Sugar())
+	if _, err := NewServer(adapter, WithConnectionStateRecovery(time.Minute), logger); !errors.Is(err, ErrRecoveryUnsupported) {
+		t.Errorf("got %v, want ErrRecoveryUnsupported", err)
+	}
+	if _, err := NewServer(adapter, logger); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,161 @@
+package socketigo
+
+import (
+	"context"
+	"sync"
+)
+
+// WithShutdownEvent makes Shutdown emit the event to every connected socket
+// before disconnecting it, e.g. to tell clients to reconnect elsewhere.
+func WithShutdownEvent(eName string, args ...interface{}) ServerOption {
+	return func(s *Server) {
+		s.shutdownEvent = append([]interface{}{eName}, args...)
+	}
+}
+
+var ErrShuttingDown errMsg = errMsg{
+	Message: "Server shutting down",
+}
+
+// Shutdown gracefully stops the server. New connections are refused and
+// every socket of every namespace gets the shutdown event if any. Once the
+// packets being handled and the messages being written are done, the sockets
+// are disconnected with DRServerShuttingDown and the connections closed.
+//
+// When ctx expires before that, the sockets are disconnected and the
+// connections closed anyway, and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+
+	if s.shutdownEvent != nil {
+		for _, nsp := range s.namespaces() {
+			nsp.adapter.Broadcast(&Packet{
+				Type:      PacketEvent,
+				Namespace: nsp.name,
+				Data:      append([]interface{}(nil), s.shutdownEvent...),
+			}, &BroadcastOptions{
+				IncludeAll: true,
+				Excludes:   map[string]struct{}{},
+				Local:      true,
+			})
+		}
+	}
+
+	err := s.inflight.wait(ctx)
+	s.Close()
+	return err
+}
+
+// Close stops the server right away, without waiting for the packets being
+// handled or the messages being written.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		s.shuttingDown.Store(true)
+		s.disconnectAll()
+
+		s.connLock.Lock()
+		conns := make([]*Connection, 0, len(s.conns))
+		for conn := range s.conns {
+			conns = append(conns, conn)
+		}
+		s.connLock.Unlock()
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+		if s.pool != nil {
+			s.pool.stop()
+		}
+		close(s.closed)
+	})
+}
+
+// disconnectAll disconnects the sockets without sending DISCONNECT packets,
+// so that clients try to reconnect.
+func (s *Server) disconnectAll() {
+	for _, nsp := range s.namespaces() {
+		nsp.RLock()
+		sockets := make([]*Socket, 0, len(nsp.sockets))
+		for _, socket := range nsp.sockets {
+			sockets = append(sockets, socket)
+		}
+		nsp.RUnlock()
+
+		for _, socket := range sockets {
+			socket.disconnect(false, DRServerShuttingDown)
+		}
+	}
+}
+
+// inflightCounter counts the packets being handled and the messages being
+// written, waking its waiters when it drops to zero.
+type inflightCounter struct {
+	lock sync.Mutex
+	cond *sync.Cond
+	n    int64
+}
+
+func newInflightCounter() *inflightCounter {
+	c := &inflightCounter{}
+	c.cond = sync.NewCond(&c.lock)
+	return c
+}
+
+func (c *inflightCounter) Add(delta int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.n += delta
+	if c.n == 0 {
+		c.cond.Broadcast()
+	}
+}
+
+// wait returns once the counter is zero, or ctx.Err() when ctx expires first.
+func (c *inflightCounter) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.lock.Lock()
+			c.cond.Broadcast()
+			c.lock.Unlock()
+		case <-done:
+		}
+	}()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for c.n > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.cond.Wait()
+	}
+	return nil
+}
+
+func (s *Server) namespaces() []*Namespace {
+	s.nspLock.RLock()
+	defer s.nspLock.RUnlock()
+
+	nsps := make([]*Namespace, 0, len(s.nsps))
+	for _, nsp := range s.nsps {
+		nsps = append(nsps, nsp)
+	}
+	return nsps
+}
+
+func (s *Server) addConn(conn *Connection) {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	s.conns[conn] = struct{}{}
+}
+
+func (s *Server) removeConn(conn *Connection) {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	delete(s.conns, conn)
+}
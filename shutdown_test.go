@@ -0,0 +1,71 @@
+package socketigo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// startWork connects a client to the server at url and sends it the "work"
+// event.
+func startWork(t *testing.T, url string, started chan struct{}) {
+	t.Helper()
+	c := connect(t, url, "/")
+	if err := c.Send(`2["work"]`); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("handler not called")
+	}
+}
+
+func TestShutdownDrainsFirst(t *testing.T) {
+	srv, url := newTestServer(t)
+	started := make(chan struct{})
+	errs := make(chan error, 1)
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		socket.On("work", func(ctx context.Context) {
+			close(started)
+			time.Sleep(100 * time.Millisecond)
+			errs <- ctx.Err()
+		})
+	})
+	startWork(t, url, started)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("the socket was disconnected while handling a packet: %v", err)
+		}
+	default:
+		t.Error("Shutdown returned before the handler")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	srv, url := newTestServer(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		socket.On("work", func() {
+			close(started)
+			<-release
+		})
+	})
+	startWork(t, url, started)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -1,29 +1,53 @@
 package socketigo
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
 	"reflect"
+	"sync"
 	"sync/atomic"
 
 	"go.uber.org/zap"
 )
 
 type Socket struct {
-	Id string
+	Id  string
+	pid string
+
+	recovered *Session
 
 	connected atomic.Bool
 
-	Handshake struct {
-		Auth map[string]interface{}
-	}
+	Handshake Handshake
 
-	Custom map[string]interface{}
+	// custom holds application data, kept by connection state recovery and
+	// returned by FetchSockets.
+	custom     map[string]interface{}
+	customLock sync.RWMutex
 
 	nsp *Namespace
 
 	conn *Connection
 	eh   EventManager
 
-	onDisconnect func(reason DisconnectReason)
+	anyIncoming anyListeners
+	anyOutgoing anyListeners
+
+	mailbox *mailbox // Nil when handlers run on the connection's read loop
+
+	ctxLock sync.RWMutex
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+
+	ackIds     atomic.Int64
+	ackLock    sync.Mutex
+	acks       map[int]*pendingAck
+	acksClosed bool
+
+	onDisconnectLock sync.Mutex
+	onDisconnect     func(reason DisconnectReason)
 
 	logger *zap.SugaredLogger
 }
@@ -32,7 +56,11 @@ func (s *Socket) Disconnect(closeConn bool) {
 	if !s.connected.CompareAndSwap(true, false) {
 		return
 	}
-	s.disconnect(closeConn, DRServerNamespaceDisconnect)
+	s.packet(&Packet{
+		Type:      PacketDisconnect,
+		Namespace: s.nsp.Name(),
+	})
+	s.onClose(closeConn, DRServerNamespaceDisconnect)
 }
 
 func (s *Socket) Join(rooms ...string) {
@@ -42,14 +70,25 @@ func (s *Socket) Leave(rooms ...string) {
 	s.nsp.adapter.Leave(s.Id, rooms...)
 }
 
+// Rooms returns the rooms the socket has joined, including its own id.
+func (s *Socket) Rooms() []string {
+	return sortedKeys(s.nsp.adapter.SocketRooms(s.Id))
+}
+
+// To broadcasts to the given rooms, skipping the socket itself.
 func (s *Socket) To(rooms ...string) *Broadcast {
-	return &Broadcast{
-		nsp:      s.nsp,
-		includes: rooms,
-		excludes: map[string]struct{}{
-			s.Id: {},
-		},
-	}
+	return s.Broadcast().To(rooms...)
+}
+
+// In is an alias of To.
+func (s *Socket) In(rooms ...string) *Broadcast {
+	return s.To(rooms...)
+}
+
+// Except broadcasts to every socket but those in the given rooms and the
+// socket itself.
+func (s *Socket) Except(rooms ...string) *Broadcast {
+	return s.Broadcast().Except(rooms...)
 }
 
 func (s *Socket) Broadcast() *Broadcast {
@@ -61,7 +100,124 @@ func (s *Socket) Broadcast() *Broadcast {
 }
 
 func (s *Socket) Emit(eName string, args ...interface{}) {
-	s.logger.Debug("Emit %s: %v", eName, args)
+	s.logger.Debugf("Emit %s: %v", eName, args)
+
+	data := append([]interface{}{eName}, args...)
+	packet := &Packet{
+		Type:      PacketEvent,
+		Namespace: s.nsp.Name(),
+		Data:      data,
+	}
+
+	// Go through the adapter so that the packet is kept for recovery.
+	if s.nsp.server.recoveryDuration > 0 {
+		s.nsp.adapter.Broadcast(packet, &BroadcastOptions{
+			Includes: []string{s.Id},
+			Excludes: make(map[string]struct{}),
+			Local:    true,
+		})
+		return
+	}
+
+	s.notifyOutgoing(packet)
+	s.packet(packet)
+}
+
+// Context returns the context of the socket, cancelled with
+// ErrSocketDisconnected as its cause once the socket disconnects.
+func (s *Socket) Context() context.Context {
+	s.ctxLock.RLock()
+	defer s.ctxLock.RUnlock()
+	return s.ctx
+}
+
+// SetValue attaches a value to the context of the socket, typically from a
+// middleware. Recovered sockets skip the middlewares and start without them.
+func (s *Socket) SetValue(key, val interface{}) {
+	s.ctxLock.Lock()
+	defer s.ctxLock.Unlock()
+	s.ctx = context.WithValue(s.ctx, key, val)
+}
+
+// GetCustom returns the value of a key of the socket's application data.
+func (s *Socket) GetCustom(key string) (interface{}, bool) {
+	s.customLock.RLock()
+	defer s.customLock.RUnlock()
+	val, ok := s.custom[key]
+	return val, ok
+}
+
+// SetCustom sets a key of the socket's application data.
+func (s *Socket) SetCustom(key string, val interface{}) {
+	s.customLock.Lock()
+	defer s.customLock.Unlock()
+	s.custom[key] = val
+}
+
+// customCopy returns a shallow copy of the application data.
+func (s *Socket) customCopy() map[string]interface{} {
+	s.customLock.RLock()
+	defer s.customLock.RUnlock()
+
+	data := make(map[string]interface{}, len(s.custom))
+	for k, v := range s.custom {
+		data[k] = v
+	}
+	return data
+}
+
+// Conn returns the underlying connection, whose ID is the Engine.IO
+// session id.
+func (s *Socket) Conn() *Connection {
+	return s.conn
+}
+
+// Recovered reports whether the socket recovered the session of a previous
+// connection, see WithConnectionStateRecovery.
+func (s *Socket) Recovered() bool {
+	return s.recovered != nil
+}
+
+// EmitWithAck emits an event and waits for the client to acknowledge it.
+// It fails with ErrAckTimeout when the deadline of ctx expires, ctx.Err()
+// when ctx is cancelled and ErrSocketDisconnected when the socket
+// disconnects first.
+//
+// Handlers run on the connection's read loop by default, so calling
+// EmitWithAck from a handler would block the very loop that receives the
+// acknowledgement; use EmitWithCallback there instead, or dispatch the
+// handlers with WithSocketQueues or WithWorkerPool.
+func (s *Socket) EmitWithAck(ctx context.Context, eName string, args ...interface{}) (*AckResponse, error) {
+	type result struct {
+		resp *AckResponse
+		err  error
+	}
+
+	ch := make(chan result, 1)
+	s.EmitWithCallback(ctx, eName, func(resp *AckResponse, err error) {
+		ch <- result{resp, err}
+	}, args...)
+
+	r := <-ch
+	return r.resp, r.err
+}
+
+// EmitWithCallback emits an event and calls callback once the client
+// acknowledges it, ctx expires or the socket disconnects.
+func (s *Socket) EmitWithCallback(ctx context.Context, eName string, callback AckCallback, args ...interface{}) {
+	s.logger.Debugf("EmitWithCallback %s: %v", eName, args)
+
+	f := callback
+	callback = func(resp *AckResponse, err error) {
+		s.guard(eName, func() {
+			f(resp, err)
+		})
+	}
+	id := s.nextAckId()
+	if !s.registerAck(ctx, id, callback) {
+		callback(nil, ErrSocketDisconnected)
+		return
+	}
 
 	data := append([]interface{}{eName}, args...)
 
@@ -69,8 +225,19 @@ func (s *Socket) Emit(eName string, args ...interface{}) {
 		Type:      PacketEvent,
 		Namespace: s.nsp.Name(),
 		Data:      data,
+		Id:        &id,
 	}
+	s.notifyOutgoing(packet)
+	s.packet(packet)
+}
+
+// nextAckId allocates the acknowledgement id of an emit, even so as not to
+// collide with the broadcast ones, see Namespace.nextAckId.
+func (s *Socket) nextAckId() int {
+	return int(s.ackIds.Add(1)-1) * 2
+}
 
+func (s *Socket) packet(packet *Packet) {
 	msgs, err := s.conn.parser.Encode(packet)
 	if err != nil {
 		s.logger.Error("s.conn.parser.Encode: ", err)
@@ -80,26 +247,82 @@ func (s *Socket) Emit(eName string, args ...interface{}) {
 	s.conn.WriteToEngine(msgs)
 }
 
-func (s *Socket) On(eName string, h any) {
-	s.eh.Register(eName, h)
+// On registers a listener for an event. Listeners of the same event are
+// called in the order they were registered. A listener may take the socket's
+// context as its first argument.
+//
+// A listener returning values, e.g. func(req T) (Resp, error), acknowledges
+// the event with them, a non-nil error being mapped by the ErrorMapper.
+func (s *Socket) On(eName string, h any) *Listener {
+	return s.eh.Register(eName, h)
+}
+
+// Once registers a listener removed after its first call.
+func (s *Socket) Once(eName string, h any) *Listener {
+	return s.eh.RegisterOnce(eName, h)
+}
+
+// Off removes a listener returned by On or Once.
+func (s *Socket) Off(eName string, l *Listener) {
+	s.eh.Remove(eName, l)
+}
+
+// RemoveAllListeners removes the listeners of the given events, or of every
+// event when called without arguments.
+func (s *Socket) RemoveAllListeners(eNames ...string) {
+	s.eh.RemoveAll(eNames...)
+}
+
+func (s *Socket) ListenerCount(eName string) int {
+	return s.eh.Count(eName)
 }
 
 func (s *Socket) OnDisconnect(f func(reason DisconnectReason)) {
+	s.onDisconnectLock.Lock()
+	defer s.onDisconnectLock.Unlock()
 	s.onDisconnect = f
 }
 
+// disconnect disconnects the socket unless it already is, paths racing with
+// each other running the disconnection once.
 func (s *Socket) disconnect(closeConn bool, reason DisconnectReason) {
+	if !s.connected.CompareAndSwap(true, false) {
+		return
+	}
+	s.onClose(closeConn, reason)
+}
+
+func (s *Socket) onClose(closeConn bool, reason DisconnectReason) {
+	if s.nsp.server.recoveryDuration > 0 && reason.recoverable() {
+		s.nsp.adapter.PersistSession(&Session{
+			Sid:   s.Id,
+			Pid:   s.pid,
+			Rooms: s.Rooms(),
+			Data:  s.customCopy(),
+		})
+	}
 	s.nsp.Remove(s.Id)
-	delete(s.conn.socketIds, s.Id)
+	s.conn.removeSocket(s)
+	s.clearAcks()
+	s.cancel(ErrSocketDisconnected)
+	if s.mailbox != nil {
+		s.mailbox.close()
+	}
 
 	if closeConn {
 		s.conn.Close()
 	}
 
-	if s.onDisconnect == nil {
+	s.onDisconnectLock.Lock()
+	onDisconnect := s.onDisconnect
+	s.onDisconnectLock.Unlock()
+
+	if onDisconnect == nil {
 		return
 	}
-	s.onDisconnect(reason)
+	s.guard(EventDisconnect, func() {
+		onDisconnect(reason)
+	})
 }
 
 func (s *Socket) dispatch(packet *Packet) {
@@ -110,37 +333,110 @@ func (s *Socket) dispatch(packet *Packet) {
 		s.logger.Errorf("ParseEventName %v: %v", packet, err)
 		return
 	}
-	h := s.eh.GetHandler(name)
 
-	if h == nil {
-		return
+	var ack func(args ...interface{})
+	if packet.Id != nil {
+		// Only the first of the listeners acknowledging the event is sent.
+		var once sync.Once
+		ack = func(args ...interface{}) {
+			once.Do(func() {
+				ackPacket := &Packet{
+					Type:      PacketAck,
+					Namespace: packet.Namespace,
+					Data:      args,
+					Id:        packet.Id,
+				}
+
+				s.logger.Debugf("Acking packet %v: %v", *ackPacket.Id, ackPacket)
+				msgs, err := s.conn.parser.Encode(ackPacket)
+				if err != nil {
+					s.logger.Error("s.conn.parser.Encode: ", err)
+					return
+				}
+				s.conn.WriteToEngine(msgs)
+			})
+		}
 	}
 
-	args, err := s.conn.parser.ParseEventArgs(packet, h.types, h.f.Type().IsVariadic())
-	if err != nil {
-		s.logger.Errorf("ParseEventArgs %v: %v", packet, err)
+	if s.anyIncoming.len() > 0 {
+		s.dispatchAny(name, packet, ack)
+	}
+
+	reply := ack
+	if reply == nil {
+		reply = func(...interface{}) {}
+	}
+
+	listeners := s.eh.fire(name)
+	if len(listeners) == 0 && s.anyIncoming.len() == 0 {
+		s.handleError(name, ErrUnknownEvent, ack)
 		return
 	}
 
-	if packet.Id != nil {
-		ack := func(args ...interface{}) {
-			ackPacket := &Packet{
-				Type:      PacketAck,
-				Namespace: packet.Namespace,
-				Data:      args,
-				Id:        packet.Id,
-			}
-
-			s.logger.Debugf("Acking packet %v: %v", *ackPacket.Id, ackPacket)
-			msgs, err := s.conn.parser.Encode(ackPacket)
-			if err != nil {
-				s.logger.Error("s.conn.parser.Encode: ", err)
-				return
-			}
-			s.conn.WriteToEngine(msgs)
+	for _, l := range listeners {
+		l := l
+		if err := s.invoke(func() error {
+			return s.call(l, packet, ack, reply)
+		}); err != nil {
+			s.handleError(name, err, ack)
 		}
+	}
+}
+
+func (s *Socket) call(l *Listener, packet *Packet, ack, reply func(...interface{})) error {
+	if l.call != nil {
+		return l.call(s, packet, reply)
+	}
+
+	args, err := s.conn.parser.ParseEventArgs(packet, l.types, l.f.Type().IsVariadic())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+	args = l.pad(args)
+
+	switch {
+	case l.takesAck(len(args)):
+		args = append(args, reflect.ValueOf(reply))
+	case ack != nil && l.f.Type().IsVariadic():
 		args = append(args, reflect.ValueOf(ack))
 	}
 
-	h.f.Call(args)
+	if results := l.f.Call(l.withContext(s.Context(), args)); len(results) > 0 {
+		reply(s.nsp.server.ackArgs(results)...)
+	}
+	return nil
+}
+
+// generateId returns a random base64 id, like the base64id package of the
+// reference implementation.
+func generateId() string {
+	bs := make([]byte, 15)
+	if _, err := rand.Read(bs); err != nil {
+		panic(err)
+	}
+	return base64.URLEncoding.EncodeToString(bs)
+}
+
+// dispatchAny calls the catch-all listeners, which are the only ones taking
+// the event arguments as generic values.
+func (s *Socket) dispatchAny(name string, packet *Packet, ack func(...interface{})) {
+	payload, err := eventData(s.conn.parser, packet)
+	if err != nil {
+		s.handleError(name, fmt.Errorf("%w: %w", ErrDecode, err), ack)
+		return
+	}
+	data, ok := payload.([]interface{})
+	if !ok {
+		return
+	}
+	args := append([]interface{}(nil), data[1:]...)
+	if ack != nil {
+		args = append(args, ack)
+	}
+	if err := s.invoke(func() error {
+		s.anyIncoming.call(name, args)
+		return nil
+	}); err != nil {
+		s.handleError(name, err, ack)
+	}
 }
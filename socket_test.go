@@ -0,0 +1,204 @@
+package socketigo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/taogames/socket.igo/internal/sockettest"
+)
+
+// Disconnections racing with each other run the disconnect handler once.
+func TestDisconnectOnce(t *testing.T) {
+	srv, url := newTestServer(t)
+	sockets := make(chan *Socket, 1)
+	var disconnects atomic.Int32
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		socket.OnDisconnect(func(DisconnectReason) {
+			disconnects.Add(1)
+		})
+		sockets <- socket
+	})
+	connect(t, url, "/")
+
+	var socket *Socket
+	select {
+	case socket = <-sockets:
+	case <-time.After(time.Second):
+		t.Fatal("not connected")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			socket.Disconnect(false)
+		}()
+		go func() {
+			defer wg.Done()
+			socket.disconnect(false, DRClientNamespaceDisconnect)
+		}()
+	}
+	wg.Wait()
+
+	if n := disconnects.Load(); n != 1 {
+		t.Errorf("disconnect handler ran %d times", n)
+	}
+}
+
+// newListenerSocket connects a client and returns its socket, whose "done"
+// listener reports the events handled before it.
+func newListenerSocket(t *testing.T) (*sockettest.Client, *Socket, chan string) {
+	t.Helper()
+	srv, url := newTestServer(t)
+	sockets := make(chan *Socket, 1)
+	calls := make(chan string, 16)
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		socket.On("done", func() {
+			calls <- "done"
+		})
+		sockets <- socket
+	})
+	c := connect(t, url, "/")
+	select {
+	case socket := <-sockets:
+		return c, socket, calls
+	case <-time.After(time.Second):
+		t.Fatal("not connected")
+	}
+	return nil, nil, nil
+}
+
+// send sends the events followed by "done", returning the listener calls
+// they made.
+func send(t *testing.T, c *sockettest.Client, calls chan string, events ...string) []string {
+	t.Helper()
+	for _, event := range append(events, "done") {
+		if err := c.Send(`2["` + event + `"]`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	for {
+		select {
+		case call := <-calls:
+			if call == "done" {
+				return got
+			}
+			got = append(got, call)
+		case <-time.After(time.Second):
+			t.Fatalf("events not handled, got %v", got)
+		}
+	}
+}
+
+func TestOnce(t *testing.T) {
+	c, socket, calls := newListenerSocket(t)
+	socket.Once("a", func() { calls <- "once" })
+	socket.On("a", func() { calls <- "on" })
+	if n := socket.ListenerCount("a"); n != 2 {
+		t.Errorf("got %d listeners, want 2", n)
+	}
+
+	if got := strings.Join(send(t, c, calls, "a", "a"), ","); got != "once,on,on" {
+		t.Errorf("got calls %s", got)
+	}
+	if n := socket.ListenerCount("a"); n != 1 {
+		t.Errorf("got %d listeners after the call, want 1", n)
+	}
+}
+
+func TestOff(t *testing.T) {
+	c, socket, calls := newListenerSocket(t)
+	first := socket.On("a", func() { calls <- "first" })
+	socket.On("a", func() { calls <- "second" })
+	once := socket.Once("a", func() { calls <- "once" })
+
+	socket.Off("a", first)
+	socket.Off("a", once)
+	if n := socket.ListenerCount("a"); n != 1 {
+		t.Errorf("got %d listeners, want 1", n)
+	}
+	if got := strings.Join(send(t, c, calls, "a"), ","); got != "second" {
+		t.Errorf("got calls %s", got)
+	}
+
+	// Removing a listener again, or from another event, changes nothing.
+	socket.Off("a", first)
+	socket.Off("b", first)
+	if n := socket.ListenerCount("a"); n != 1 {
+		t.Errorf("got %d listeners, want 1", n)
+	}
+}
+
+func TestRemoveAllListeners(t *testing.T) {
+	c, socket, calls := newListenerSocket(t)
+	socket.On("a", func() { calls <- "a" })
+	socket.Once("a", func() { calls <- "a once" })
+	socket.On("b", func() { calls <- "b" })
+
+	socket.RemoveAllListeners("a")
+	if n := socket.ListenerCount("a"); n != 0 {
+		t.Errorf("got %d listeners of a, want 0", n)
+	}
+	if n := socket.ListenerCount("b"); n != 1 {
+		t.Errorf("got %d listeners of b, want 1", n)
+	}
+	if got := strings.Join(send(t, c, calls, "a", "b"), ","); got != "b" {
+		t.Errorf("got calls %s", got)
+	}
+
+	socket.RemoveAllListeners()
+	for _, event := range []string{"a", "b", "done"} {
+		if n := socket.ListenerCount(event); n != 0 {
+			t.Errorf("got %d listeners of %s, want 0", n, event)
+		}
+	}
+}
+
+type ctxKey struct{}
+
+func TestSocketContext(t *testing.T) {
+	srv, url := newTestServer(t)
+	srv.Of("/").Use(func(socket *Socket, next func(error)) {
+		socket.SetValue(ctxKey{}, "value")
+		next(nil)
+	})
+	contexts := make(chan context.Context, 1)
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		socket.On("get", func(ctx context.Context) string {
+			v, _ := ctx.Value(ctxKey{}).(string)
+			return v
+		})
+		contexts <- socket.Context()
+	})
+	c := connect(t, url, "/")
+	ctx := <-contexts
+
+	if err := c.Send(`21["get"]`); err != nil {
+		t.Fatal(err)
+	}
+	if ack, err := c.Expect(time.Second); err != nil || ack != `31["value"]` {
+		t.Errorf("got %q, %v", ack, err)
+	}
+
+	if ctx.Err() != nil {
+		t.Fatalf("context done while connected: %v", ctx.Err())
+	}
+	if err := c.Send(`1`); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+		if cause := context.Cause(ctx); !errors.Is(cause, ErrSocketDisconnected) {
+			t.Errorf("got cause %v, want ErrSocketDisconnected", cause)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled on disconnect")
+	}
+}
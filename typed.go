@@ -0,0 +1,86 @@
+package socketigo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// ArgsDecoder is implemented by the parsers able to decode the arguments of
+// an event straight into values, without going through reflection. Missing
+// arguments leave their destination untouched, extra ones are ignored.
+type ArgsDecoder interface {
+	DecodeEventArgs(packet *Packet, dst ...interface{}) error
+}
+
+// decodeEventArgs wraps the decoding errors with ErrDecode.
+func decodeEventArgs(parser Parser, packet *Packet, dst ...interface{}) error {
+	if dec, ok := parser.(ArgsDecoder); ok {
+		if err := dec.DecodeEventArgs(packet, dst...); err != nil {
+			return fmt.Errorf("%w: %w", ErrDecode, err)
+		}
+		return nil
+	}
+
+	types := make([]reflect.Type, len(dst))
+	for i := range dst {
+		types[i] = reflect.TypeOf(dst[i]).Elem()
+	}
+	args, err := parser.ParseEventArgs(packet, types, false)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+	for i := range args {
+		reflect.ValueOf(dst[i]).Elem().Set(args[i])
+	}
+	return nil
+}
+
+// On registers a listener taking the first argument of the event decoded
+// into T, checked at compile time unlike Socket.On. The other arguments are
+// ignored, use Socket.On for events carrying several.
+func On[T any](s *Socket, eName string, f func(T)) *Listener {
+	return OnContext(s, eName, func(_ context.Context, arg T) {
+		f(arg)
+	})
+}
+
+// OnContext is like On, also passing the socket context to f.
+func OnContext[T any](s *Socket, eName string, f func(ctx context.Context, arg T)) *Listener {
+	return s.eh.add(eName, &Listener{
+		call: func(s *Socket, packet *Packet, _ func(...interface{})) error {
+			var arg T
+			if err := decodeEventArgs(s.conn.parser, packet, &arg); err != nil {
+				return err
+			}
+			f(s.Context(), arg)
+			return nil
+		},
+	})
+}
+
+// OnAck is like On, acknowledging the event with the value returned by f.
+func OnAck[Req, Resp any](s *Socket, eName string, f func(Req) Resp) *Listener {
+	return OnAckContext(s, eName, func(_ context.Context, req Req) Resp {
+		return f(req)
+	})
+}
+
+// OnAckContext is like OnAck, also passing the socket context to f.
+func OnAckContext[Req, Resp any](s *Socket, eName string, f func(ctx context.Context, req Req) Resp) *Listener {
+	return s.eh.add(eName, &Listener{
+		call: func(s *Socket, packet *Packet, ack func(...interface{})) error {
+			var req Req
+			if err := decodeEventArgs(s.conn.parser, packet, &req); err != nil {
+				return err
+			}
+			ack(f(s.Context(), req))
+			return nil
+		},
+	})
+}
+
+// Emit emits an event with a single argument of type T.
+func Emit[T any](s *Socket, eName string, v T) {
+	s.Emit(eName, v)
+}
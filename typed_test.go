@@ -0,0 +1,90 @@
+package socketigo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type greeting struct {
+	Name string `json:"name"`
+}
+
+func TestTypedListeners(t *testing.T) {
+	srv, url := newTestServer(t)
+	names := make(chan string, 1)
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		On(socket, "hello", func(g greeting) {
+			names <- g.Name
+		})
+		OnAck(socket, "double", func(n int) int {
+			return n * 2
+		})
+		On(socket, "echo", func(g greeting) {
+			Emit(socket, "echo", g)
+		})
+	})
+	c := connect(t, url, "/")
+
+	if err := c.Send(`2["hello",{"name":"a"},"ignored"]`); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-names:
+		if name != "a" {
+			t.Errorf("got %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("listener not called")
+	}
+
+	if err := c.Send(`21["double",21]`); err != nil {
+		t.Fatal(err)
+	}
+	if ack, err := c.Expect(time.Second); err != nil || ack != `31[42]` {
+		t.Errorf("got %q, %v", ack, err)
+	}
+
+	if err := c.Send(`2["echo",{"name":"b"}]`); err != nil {
+		t.Fatal(err)
+	}
+	if packet, err := c.Expect(time.Second); err != nil || packet != `2["echo",{"name":"b"}]` {
+		t.Errorf("got %q, %v", packet, err)
+	}
+}
+
+func TestTypedContextListeners(t *testing.T) {
+	srv, url := newTestServer(t)
+	contexts := make(chan context.Context, 1)
+	srv.Of("/").OnConnection(func(socket *Socket) {
+		OnContext(socket, "hello", func(ctx context.Context, g greeting) {
+			contexts <- ctx
+		})
+		OnAckContext(socket, "double", func(ctx context.Context, n int) int {
+			if ctx.Err() != nil {
+				return 0
+			}
+			return n * 2
+		})
+	})
+	c := connect(t, url, "/")
+
+	if err := c.Send(`2["hello",{"name":"a"}]`); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ctx := <-contexts:
+		if ctx == nil || ctx.Err() != nil {
+			t.Errorf("got context %v", ctx)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("listener not called")
+	}
+
+	if err := c.Send(`21["double",21]`); err != nil {
+		t.Fatal(err)
+	}
+	if ack, err := c.Expect(time.Second); err != nil || ack != `31[42]` {
+		t.Errorf("got %q, %v", ack, err)
+	}
+}